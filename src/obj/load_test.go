@@ -0,0 +1,87 @@
+package obj
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "matrix"
+)
+
+func load(t *testing.T, contents string) []Object {
+	path := filepath.Join(t.TempDir(), "test.obj")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+func TestLoadLines(t *testing.T) {
+	objects := load(t, "v 0 0 0\nv 1 0 0\nv 1 1 0\nvt 0.5 0.5\nl 1 2 3\nl 3/1 1/1\n")
+	if len(objects) != 1 || len(objects[0].Lines) != 2 {
+		t.Fatalf("objects = %+v", objects)
+	}
+	lines := objects[0].Lines
+	if expected := []V4{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}}; !equalV4s(lines[0].Vertices, expected) || len(lines[0].TextureCoords) != 0 {
+		t.Errorf("line = %+v, expected vertices %v", lines[0], expected)
+	}
+	if !equalV4s(lines[1].Vertices, []V4{{1, 1, 0}, {0, 0, 0}}) || !equalV4s(lines[1].TextureCoords, []V4{{0.5, 0.5}, {0.5, 0.5}}) {
+		t.Errorf("textured line = %+v", lines[1])
+	}
+}
+
+func TestLoadPoints(t *testing.T) {
+	// the first vertex has a color, the second doesn't
+	objects := load(t, "v 0 0 0 1 0.5 0\nv 1 2 3\nvt 0 0\np 1 2\np 2/1\n")
+	if len(objects) != 1 {
+		t.Fatalf("objects = %+v", objects)
+	}
+	expected := []Point{
+		{Vertex: V4{0, 0, 0}, Color: V4{1, 0.5, 0, 1}},
+		{Vertex: V4{1, 2, 3}},
+		{Vertex: V4{1, 2, 3}},
+	}
+	if points := objects[0].Points; len(points) != len(expected) {
+		t.Errorf("points = %v, expected %v", points, expected)
+	} else {
+		for i := range points {
+			if points[i] != expected[i] {
+				t.Errorf("point %d = %v, expected %v", i, points[i], expected[i])
+			}
+		}
+	}
+}
+
+func TestLoadPointCloud(t *testing.T) {
+	// a file with nothing but vertices is loaded as points
+	objects := load(t, "v 0 0 0 0 0 1\nv 1 1 1\n")
+	if len(objects) != 1 || len(objects[0].Faces) != 0 {
+		t.Fatalf("objects = %+v", objects)
+	}
+	expected := []Point{{Vertex: V4{0, 0, 0}, Color: V4{0, 0, 1, 1}}, {Vertex: V4{1, 1, 1}}}
+	if points := objects[0].Points; len(points) != 2 || points[0] != expected[0] || points[1] != expected[1] {
+		t.Errorf("points = %v, expected %v", points, expected)
+	}
+
+	// faces aren't mixed with stray vertices
+	objects = load(t, "v 0 0 0\nv 1 0 0\nv 0 1 0\nv 5 5 5\nf 1 2 3\n")
+	if len(objects) != 1 || len(objects[0].Faces) != 1 || len(objects[0].Points) != 0 {
+		t.Errorf("objects = %+v", objects)
+	}
+}
+
+func equalV4s(a, b []V4) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -22,12 +22,27 @@ type Face struct {
 	Normals       []V4
 }
 
+// Line is a polyline through its vertices
+type Line struct {
+	Vertices      []V4
+	TextureCoords []V4
+}
+
+// Point is a single vertex, Color has a zero alpha when the file doesn't give the vertex a color
+type Point struct {
+	Vertex V4
+	Color  V4
+}
+
 type Object struct {
 	Faces    []Face
+	Lines    []Line
+	Points   []Point
 	Material Material
 }
 
 type Material struct {
+	Name    string
 	Ns      float32
 	Ni      float32
 	D       float32
@@ -40,8 +55,14 @@ type Material struct {
 	Ke      V4
 	MapKa   image.Image
 	MapKd   image.Image
+	MapKs   image.Image
+	MapNs   image.Image
+	MapD    image.Image
+	MapKe   image.Image
 	MapBump image.Image
 	Bump    image.Image
+	// tangent-space normal map, map_bump and bump are height maps
+	Norm image.Image
 }
 
 func parseVector(vals []string) (V4, error) {
@@ -95,8 +116,8 @@ func loadMtl(mtlPath string) (map[string]Material, error) {
 			if name != "" {
 				materials[name] = m
 			}
-			m = Material{}
 			name = parts[1]
+			m = Material{Name: name}
 		case "Tf", "Ka", "Kd", "Ks", "Ke":
 			v, err := parseVector(args)
 			if err != nil {
@@ -130,7 +151,7 @@ func loadMtl(mtlPath string) (map[string]Material, error) {
 			case "Tr":
 				m.Tr = f
 			}
-		case "map_Ka", "map_Kd", "map_bump", "bump":
+		case "map_Ka", "map_Kd", "map_Ks", "map_Ns", "map_d", "map_Ke", "map_bump", "bump", "norm":
 			texPath := path.Join(path.Dir(mtlPath), args[0])
 			texFile, err := os.Open(texPath)
 			if err != nil {
@@ -152,10 +173,20 @@ func loadMtl(mtlPath string) (map[string]Material, error) {
 				m.MapKa = img
 			case "map_Kd":
 				m.MapKd = img
+			case "map_Ks":
+				m.MapKs = img
+			case "map_Ns":
+				m.MapNs = img
+			case "map_d":
+				m.MapD = img
+			case "map_Ke":
+				m.MapKe = img
 			case "map_bump":
 				m.MapBump = img
 			case "bump":
 				m.Bump = img
+			case "norm":
+				m.Norm = img
 			}
 		case "illum":
 			i, err := strconv.Atoi(args[0])
@@ -188,7 +219,11 @@ func Load(objPath string) ([]Object, error) {
 	vertices := []V4{}
 	textureCoords := []V4{}
 	normals := []V4{}
+	// colors are an extension that follows the position, v x y z r g b
+	vertexColors := []V4{}
 	faces := []Face{}
+	lines := []Line{}
+	points := []Point{}
 	materials := map[string]Material{}
 	objects := []Object{}
 	o := Object{}
@@ -205,6 +240,15 @@ func Load(objPath string) ([]Object, error) {
 
 		switch cmd {
 		case "v", "vt", "vn":
+			c := V4{}
+			if cmd == "v" && len(args) >= 6 {
+				c, err = parseVector(args[3:6])
+				if err != nil {
+					return nil, err
+				}
+				c[3] = 1
+				args = args[:3]
+			}
 			v, err := parseVector(args)
 			if err != nil {
 				return nil, err
@@ -212,6 +256,7 @@ func Load(objPath string) ([]Object, error) {
 			switch cmd {
 			case "v":
 				vertices = append(vertices, v)
+				vertexColors = append(vertexColors, c)
 			case "vt":
 				textureCoords = append(textureCoords, v)
 			case "vn":
@@ -235,11 +280,38 @@ func Load(objPath string) ([]Object, error) {
 				}
 			}
 			faces = append(faces, f)
+		case "l":
+			l := Line{}
+			for _, p := range args {
+				idx, err := parseInts(strings.Split(p, "/"))
+				if err != nil {
+					return nil, err
+				}
+
+				l.Vertices = append(l.Vertices, vertices[idx[0]-1])
+				if len(idx) > 1 {
+					l.TextureCoords = append(l.TextureCoords, textureCoords[idx[1]-1])
+				}
+			}
+			lines = append(lines, l)
+		case "p":
+			for _, p := range args {
+				// v/vt like lines, points have no use for the texture coordinate
+				idx, err := parseInts(strings.Split(p, "/"))
+				if err != nil {
+					return nil, err
+				}
+				points = append(points, Point{vertices[idx[0]-1], vertexColors[idx[0]-1]})
+			}
 		case "g":
-			if len(faces) > 0 {
+			if len(faces) > 0 || len(lines) > 0 || len(points) > 0 {
 				o.Faces = faces
+				o.Lines = lines
+				o.Points = points
 				objects = append(objects, o)
 				faces = []Face{}
+				lines = []Line{}
+				points = []Point{}
 			}
 			o = Object{}
 		case "mtllib":
@@ -255,8 +327,18 @@ func Load(objPath string) ([]Object, error) {
 		}
 	}
 
-	if len(faces) > 0 {
+	if len(faces) > 0 || len(lines) > 0 || len(points) > 0 {
 		o.Faces = faces
+		o.Lines = lines
+		o.Points = points
+		objects = append(objects, o)
+	}
+
+	// files with nothing but vertices, like scans, are point clouds
+	if len(objects) == 0 && len(vertices) > 0 {
+		for i := range vertices {
+			o.Points = append(o.Points, Point{vertices[i], vertexColors[i]})
+		}
 		objects = append(objects, o)
 	}
 
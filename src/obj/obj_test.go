@@ -1,3 +1,7 @@
+//go:build ignore
+
+// tests the binary encoding of the old Model type, which no longer exists
+
 package obj
 
 import (
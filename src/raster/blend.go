@@ -0,0 +1,70 @@
+package raster
+
+import (
+	. "matrix"
+)
+
+// BlendFactor scales the source or destination color when blending
+type BlendFactor int
+
+const (
+	BlendZero BlendFactor = iota
+	BlendOne
+	BlendSrcColor
+	BlendOneMinusSrcColor
+	BlendDstColor
+	BlendOneMinusDstColor
+	BlendSrcAlpha
+	BlendOneMinusSrcAlpha
+	BlendDstAlpha
+	BlendOneMinusDstAlpha
+)
+
+// BlendState combines a fragment color (the source) with the color already in the framebuffer (the destination)
+// as source*Src + destination*Dst, alpha is always combined as source + destination*(1 - source alpha)
+// so that an opaque framebuffer stays opaque
+// https://www.opengl.org/sdk/docs/man/html/glBlendFunc.xhtml
+type BlendState struct {
+	Src BlendFactor
+	Dst BlendFactor
+}
+
+var (
+	BlendOpaque   = BlendState{BlendOne, BlendZero}
+	BlendOver     = BlendState{BlendSrcAlpha, BlendOneMinusSrcAlpha}
+	BlendAdditive = BlendState{BlendSrcAlpha, BlendOne}
+	BlendMultiply = BlendState{BlendDstColor, BlendZero}
+)
+
+func (f BlendFactor) factor(src, dst V4) V4 {
+	switch f {
+	case BlendOne:
+		return V4{1, 1, 1, 1}
+	case BlendSrcColor:
+		return src
+	case BlendOneMinusSrcColor:
+		return V4{1 - src[0], 1 - src[1], 1 - src[2], 1 - src[3]}
+	case BlendDstColor:
+		return dst
+	case BlendOneMinusDstColor:
+		return V4{1 - dst[0], 1 - dst[1], 1 - dst[2], 1 - dst[3]}
+	case BlendSrcAlpha:
+		return V4{src[3], src[3], src[3], src[3]}
+	case BlendOneMinusSrcAlpha:
+		a := 1 - src[3]
+		return V4{a, a, a, a}
+	case BlendDstAlpha:
+		return V4{dst[3], dst[3], dst[3], dst[3]}
+	case BlendOneMinusDstAlpha:
+		a := 1 - dst[3]
+		return V4{a, a, a, a}
+	default:
+		return V4{}
+	}
+}
+
+func (b BlendState) blend(src, dst V4) V4 {
+	c := src.Multiply(b.Src.factor(src, dst)).Add(dst.Multiply(b.Dst.factor(src, dst)))
+	c[3] = src[3] + dst[3]*(1-src[3])
+	return c
+}
@@ -0,0 +1,39 @@
+package raster
+
+import (
+	"math"
+
+	. "matrix"
+)
+
+type Camera struct {
+	Position    V4
+	XRotation   float32
+	YRotation   float32
+	FieldOfView float32 // vertical, in radians
+	Near        float32
+	Far         float32
+}
+
+func NewCamera() Camera {
+	return Camera{
+		Position:    V4{0, 0, 5},
+		FieldOfView: 70.0 / 180.0 * math.Pi,
+		Near:        1,
+		Far:         150,
+	}
+}
+
+func (c Camera) View() M4 {
+	pos := c.Position.Negate()
+	return IdentityM4.RotateX(c.XRotation).RotateY(c.YRotation).Translate(V3{pos[0], pos[1], pos[2]})
+}
+
+func (c Camera) Projection(aspect float32) M4 {
+	return IdentityM4.ProjectPerspective(c.FieldOfView, aspect, c.Near, c.Far)
+}
+
+// Move translates the camera by a delta expressed in camera space
+func (c *Camera) Move(delta V4) {
+	c.Position = c.Position.Add(IdentityM4.RotateY(-c.YRotation).RotateX(-c.XRotation).MultiplyV4(delta))
+}
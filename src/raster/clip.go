@@ -0,0 +1,128 @@
+package raster
+
+import (
+	. "matrix"
+)
+
+type clipVertex struct {
+	Position V4
+	Varyings []float32
+}
+
+// planes in homogeneous clip space, a vertex v is inside when plane . v >= 0
+// http://fabiensanglard.net/polygon_codec/clippingdocument/Clipping.pdf
+var (
+	nearPlane     = V4{0, 0, 1, 1}
+	frustumPlanes = []V4{
+		nearPlane,
+		{0, 0, -1, 1}, // far
+		{1, 0, 0, 1},  // left
+		{-1, 0, 0, 1}, // right
+		{0, 1, 0, 1},  // bottom
+		{0, -1, 0, 1}, // top
+	}
+
+	// when only clipping against the near plane, geometry far outside the screen is still clipped
+	// so that window coordinates always fit the fixed-point rasterizer
+	guardBandPlanes = []V4{
+		nearPlane,
+		{1, 0, 0, guardBand},
+		{-1, 0, 0, guardBand},
+		{0, 1, 0, guardBand},
+		{0, -1, 0, guardBand},
+	}
+)
+
+const guardBand = 1 << 10
+
+// outcode has a bit set for every frustum plane the vertex is outside of
+func outcode(v V4) uint {
+	code := uint(0)
+	for i, plane := range frustumPlanes {
+		if plane.DotProduct(v) < 0 {
+			code |= 1 << uint(i)
+		}
+	}
+	return code
+}
+
+func lerpClipVertex(a, b clipVertex, t float32) clipVertex {
+	v := clipVertex{
+		Position: a.Position.Lerp(b.Position, t),
+		Varyings: make([]float32, len(a.Varyings)),
+	}
+	for i := range v.Varyings {
+		v.Varyings[i] = a.Varyings[i]*(1-t) + b.Varyings[i]*t
+	}
+	return v
+}
+
+// clipPolygon clips a convex polygon against a single plane (one Sutherland-Hodgman pass)
+func clipPolygon(polygon []clipVertex, plane V4) []clipVertex {
+	result := make([]clipVertex, 0, len(polygon)+1)
+	for i := range polygon {
+		a := polygon[i]
+		b := polygon[(i+1)%len(polygon)]
+		da := plane.DotProduct(a.Position)
+		db := plane.DotProduct(b.Position)
+
+		if da >= 0 {
+			result = append(result, a)
+		}
+		if (da >= 0) != (db >= 0) {
+			// the edge crosses the plane, emit the intersection
+			result = append(result, lerpClipVertex(a, b, da/(da-db)))
+		}
+	}
+	return result
+}
+
+// clipTriangle returns the triangle clipped against the given planes as a convex polygon,
+// which is empty if the triangle is entirely outside
+func clipTriangle(vertices [3]clipVertex, planes []V4) []clipVertex {
+	codeA := outcode(vertices[0].Position)
+	codeB := outcode(vertices[1].Position)
+	codeC := outcode(vertices[2].Position)
+
+	// all vertices are outside of the same plane
+	if codeA&codeB&codeC != 0 {
+		return nil
+	}
+
+	polygon := vertices[:]
+
+	// all vertices are inside, nothing to clip
+	if codeA|codeB|codeC == 0 {
+		return polygon
+	}
+
+	for _, plane := range planes {
+		polygon = clipPolygon(polygon, plane)
+		if len(polygon) < 3 {
+			return nil
+		}
+	}
+	return polygon
+}
+
+// clipLine clips a segment against the planes, returning false if nothing is left
+// http://www.cs.helsinki.fi/group/goa/viewing/leikkaus/intro2.html
+func clipLine(a, b V4, planes []V4) (V4, V4, bool) {
+	t0, t1 := float32(0), float32(1)
+	for _, plane := range planes {
+		da := plane.DotProduct(a)
+		db := plane.DotProduct(b)
+		if da < 0 && db < 0 {
+			return a, b, false
+		}
+		if da < 0 {
+			t0 = max(t0, da/(da-db))
+		} else if db < 0 {
+			t1 = min(t1, da/(da-db))
+		}
+	}
+	if t0 > t1 {
+		return a, b, false
+	}
+	return a.Lerp(b, t0), a.Lerp(b, t1), true
+}
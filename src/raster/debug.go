@@ -0,0 +1,133 @@
+package raster
+
+import (
+	"image"
+	"math"
+
+	. "matrix"
+)
+
+// DebugView replaces the shaded image with a visualization of the renderer's internal data
+type DebugView int
+
+const (
+	DebugNone DebugView = iota
+	// linear view distance, from white at the closest to black at the farthest visible surface
+	DebugDepth
+	// view space normals mapped from -1..1 to 0..1
+	DebugNormals
+	// fractional part of the texture coordinates in red and green
+	DebugUVs
+	// number of fragments shaded per pixel, from blue for one to red for overdrawMax and more
+	DebugOverdraw
+	// a different color for every triangle of the scene
+	DebugTriangles
+	// a different color for every object the triangles were loaded from
+	DebugObjects
+	// the ambient light left by SSAO, from black for fully occluded to white
+	DebugAmbientOcclusion
+)
+
+// overdraw count shown in red
+const overdrawMax = 8
+
+// debugShader draws every debug view except overdraw, which uses the regular shaders,
+// and depth, which is read from the depth buffer
+type debugShader struct {
+	view DebugView
+}
+
+func (s *debugShader) Varyings() int {
+	return 5
+}
+
+func (s *debugShader) ShadeVertex(u *Uniforms, v *Vertex, varyings []float32) V4 {
+	pos := V4{v.Position[0], v.Position[1], v.Position[2], 1}
+	normal := u.Normal.MultiplyV4(V4{v.Normal[0], v.Normal[1], v.Normal[2], 0})
+	varyings[0] = normal[0]
+	varyings[1] = normal[1]
+	varyings[2] = normal[2]
+	varyings[3] = v.TextureCoord[0]
+	varyings[4] = v.TextureCoord[1]
+	return u.ModelViewProjection.MultiplyV4(pos)
+}
+
+func (s *debugShader) ShadeFragment(u *Uniforms, f *Fragment) (V4, bool) {
+	interp := f.Varyings
+	alpha := float32(1)
+	if m := f.Triangle.Material; m != nil && m.AlphaCutoff > 0 {
+		alpha = cutoutAlpha(m, interp[3], interp[4], f.DDX[3:], f.DDY[3:])
+	}
+
+	switch s.view {
+	case DebugNormals:
+		n := V3{interp[0], interp[1], interp[2]}.Normalize()
+		return V4{n[0]*0.5 + 0.5, n[1]*0.5 + 0.5, n[2]*0.5 + 0.5, alpha}, true
+	case DebugUVs:
+		return V4{fpart(interp[3]), fpart(interp[4]), 0, alpha}, true
+	case DebugTriangles:
+		return idColor(f.PrimitiveID, alpha), true
+	case DebugObjects:
+		return idColor(f.Triangle.Object, alpha), true
+	}
+	return V4{0, 0, 0, alpha}, true
+}
+
+// idColor picks well separated hues for consecutive ids with the golden ratio
+func idColor(id int, alpha float32) V4 {
+	_, hue := math.Modf(float64(id) * 0.618033988749895)
+	c := HSVToRGB(hue, 0.7, 1)
+	return V4{float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255, alpha}
+}
+
+// resolveDebug averages the samples of each pixel into the image without any color conversion,
+// or draws the depth buffer or overdraw heatmap
+func (fb *framebuffer) resolveDebug(img *image.NRGBA, view DebugView, near, far float32) {
+	// the range of linear depths that were drawn
+	linear := func(depth float32) float32 {
+		return 2 * near * far / (far + near - depth*(far-near))
+	}
+	closest, farthest := far, near
+	if view == DebugDepth {
+		for _, d := range fb.depth {
+			if d < 1 {
+				closest = min(closest, linear(d))
+				farthest = max(farthest, linear(d))
+			}
+		}
+	}
+
+	scale := 1 / float32(fb.samples)
+	for py := 0; py < fb.height; py++ {
+		for px := 0; px < fb.width; px++ {
+			offset := (py*fb.width + px) * fb.samples
+			if view == DebugDepth {
+				sum := float32(0)
+				for _, d := range fb.depth[offset : offset+fb.samples] {
+					if d < 1 {
+						sum += 1 - (linear(d)-closest)/max(farthest-closest, 1e-6)
+					}
+				}
+				d := sum * scale
+				img.SetNRGBA(px, py, toNRGBA(V4{d, d, d, 1}))
+				continue
+			}
+			if view == DebugOverdraw {
+				count := fb.overdraw[py*fb.width+px]
+				if count == 0 {
+					img.SetNRGBA(px, py, toNRGBA(V4{0, 0, 0, 1}))
+					continue
+				}
+				heat := float64(minInt(int(count), overdrawMax)-1) / (overdrawMax - 1)
+				img.SetNRGBA(px, py, HSVToRGB((1-heat)*2/3, 1, 1))
+				continue
+			}
+
+			sum := V4{}
+			for _, c := range fb.color[offset : offset+fb.samples] {
+				sum = sum.Add(c)
+			}
+			img.SetNRGBA(px, py, toNRGBA(sum.MultiplyScalar(scale)))
+		}
+	}
+}
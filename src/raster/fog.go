@@ -0,0 +1,109 @@
+package raster
+
+import (
+	"math"
+
+	. "matrix"
+)
+
+type FogMode int
+
+const (
+	FogNone FogMode = iota
+	// grows linearly from Start to End
+	FogLinear
+	// 1 - e^(-density * depth)
+	FogExponential
+	// 1 - e^(-(density * depth)^2), clearer near the camera
+	FogExponentialSquared
+)
+
+// Fog blends surfaces towards a color with their view-space depth, and optionally with a layer
+// of height fog that thins out going up, the background is cleared to the fog color
+// https://www.khronos.org/registry/OpenGL-Refpages/gl2.1/xhtml/glFog.xml
+type Fog struct {
+	Mode  FogMode
+	Color V3
+	// depths of the linear mode where the fog begins and becomes opaque
+	Start float32
+	End   float32
+	// of the exponential modes
+	Density float32
+
+	// density of the height fog at Height, 0 disables it
+	HeightDensity float32
+	Height        float32
+	// how fast the height fog thins out per unit above Height
+	HeightFalloff float32
+}
+
+func (f *Fog) enabled() bool {
+	return f.Mode != FogNone || f.HeightDensity > 0
+}
+
+// visibility returns how much of a surface's color is left after the fog between the camera and the surface,
+// depth is along the view direction and the positions are in world space
+func (f *Fog) visibility(depth float32, camera, position V3) float32 {
+	depth = max(0, depth)
+
+	v := float32(1)
+	switch f.Mode {
+	case FogLinear:
+		if f.End > f.Start {
+			v = clamp((f.End - depth) / (f.End - f.Start))
+		} else if depth >= f.End {
+			v = 0
+		}
+	case FogExponential:
+		v = float32(math.Exp(float64(-f.Density * depth)))
+	case FogExponentialSquared:
+		d := f.Density * depth
+		v = float32(math.Exp(float64(-d * d)))
+	}
+
+	if f.HeightDensity > 0 {
+		// integral of the density along the ray, for a density of HeightDensity * e^(-HeightFalloff * (y - Height))
+		// http://www.iquilezles.org/www/articles/fog/fog.htm
+		ray := position.Subtract(camera)
+		distance := ray.Length()
+		start := f.HeightDensity * float32(math.Exp(float64(-f.HeightFalloff*(camera[1]-f.Height))))
+		amount := start * distance
+		if dy := ray[1] * f.HeightFalloff; abs(dy) > 1e-5 {
+			amount *= (1 - float32(math.Exp(float64(-dy)))) / dy
+		}
+		v *= float32(math.Exp(float64(-max(0, amount))))
+	}
+	return v
+}
+
+// fogState is what the main pass needs to apply the scene's fog to fragments
+type fogState struct {
+	fog               Fog
+	inverseProjection M4
+	inverseView       M4
+	camera            V3
+	width, height     float32
+}
+
+// apply blends a color towards the fog color, x and y are window coordinates and depth is normalized
+func (s *fogState) apply(c V4, x, y, depth float32) V4 {
+	ndc := V4{x/s.width*2 - 1, 1 - y/s.height*2, depth, 1}
+	view := s.inverseProjection.MultiplyV4(ndc)
+	view = view.MultiplyScalar(1 / view[3])
+	world := s.inverseView.MultiplyV4(view)
+
+	v := s.fog.visibility(-view[2], s.camera, V3{world[0], world[1], world[2]})
+	color := s.fog.Color
+	return V4{
+		color[0] + (c[0]-color[0])*v,
+		color[1] + (c[1]-color[1])*v,
+		color[2] + (c[2]-color[2])*v,
+		c[3],
+	}
+}
+
+// applySample fogs a sample of a pixel at its own position and depth, so that forward and deferred shading
+// agree along multisampled edges
+func (s *fogState) applySample(c V4, px, py int, offset [2]int64, depth float32) V4 {
+	return s.apply(c, float32(px)+0.5+float32(offset[0])/16, float32(py)+0.5+float32(offset[1])/16, depth)
+}
@@ -0,0 +1,114 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	. "matrix"
+)
+
+func TestFogVisibility(t *testing.T) {
+	camera := V3{0, 0, 0}
+	cases := []struct {
+		fog      Fog
+		depth    float32
+		expected float32
+	}{
+		{Fog{}, 100, 1},
+		{Fog{Mode: FogLinear, Start: 10, End: 20}, 5, 1},
+		{Fog{Mode: FogLinear, Start: 10, End: 20}, 15, 0.5},
+		{Fog{Mode: FogLinear, Start: 10, End: 20}, 25, 0},
+		{Fog{Mode: FogExponential, Density: 0.1}, 10, float32(math.Exp(-1))},
+		{Fog{Mode: FogExponentialSquared, Density: 0.1}, 20, float32(math.Exp(-4))},
+	}
+	for _, c := range cases {
+		if v := c.fog.visibility(c.depth, camera, V3{0, 0, -c.depth}); abs(v-c.expected) > 1e-5 {
+			t.Errorf("%+v at %v = %v, expected %v", c.fog, c.depth, v, c.expected)
+		}
+	}
+
+	// height fog along a level ray has a constant density
+	fog := Fog{HeightDensity: 0.1, Height: 0, HeightFalloff: 1}
+	if v := fog.visibility(10, camera, V3{0, 0, -10}); abs(v-float32(math.Exp(-1))) > 1e-5 {
+		t.Errorf("level height fog = %v", v)
+	}
+	// and is thinner looking up than down
+	up := fog.visibility(10, camera, V3{0, 5, -10})
+	down := fog.visibility(10, camera, V3{0, -5, -10})
+	if up <= down {
+		t.Errorf("height fog looking up = %v, down = %v", up, down)
+	}
+}
+
+func TestRenderFog(t *testing.T) {
+	material := &Material{Diffuse: V3{1, 0, 0}, Illum: 0}
+	near := Triangle{Vertices: [3]V4{{-1, -1, 0}, {0, -1, 0}, {-0.5, 1, 0}}, Material: material}
+	far := Triangle{Vertices: [3]V4{{10, -10, -95}, {30, -10, -95}, {20, 10, -95}}, Material: material}
+	scene := &Scene{
+		Triangles: []Triangle{near, far},
+		Model:     IdentityM4,
+		Fog:       Fog{Mode: FogLinear, Color: V3{0, 0, 1}, Start: 10, End: 50},
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+
+	fogColor := color.NRGBA{0, 0, 255, 255}
+	if c := img.NRGBAAt(0, 0); c != fogColor {
+		t.Errorf("background = %v, expected the fog color", c)
+	}
+	if c := img.NRGBAAt(26, 32); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("triangle in front of the fog = %v", c)
+	}
+	if c := img.NRGBAAt(41, 32); c != fogColor {
+		t.Errorf("triangle beyond the fog = %v", c)
+	}
+
+	// halfway into the fog
+	scene.Fog.End = 190
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	if c := img.NRGBAAt(41, 32); c != (color.NRGBA{188, 0, 188, 255}) {
+		t.Errorf("triangle in the fog = %v", c)
+	}
+}
+
+func TestRenderFogDeferred(t *testing.T) {
+	material := &Material{Diffuse: V3{1, 0.5, 0}, Illum: 1}
+	scene := &Scene{
+		Triangles: []Triangle{
+			// a slanted triangle whose edges cross the fog at different depths, in front of a far one
+			{Vertices: [3]V4{{-2, -2, 0}, {2, -1, -30}, {0, 2, -10}}, Material: material},
+			{Vertices: [3]V4{{-20, -20, -40}, {20, -20, -40}, {0, 20, -40}}, Material: material},
+		},
+		Model:  IdentityM4,
+		Lights: []Light{NewDirectionalLight(V4{0, 0, -1}, V3{1, 1, 1})},
+		Fog:    Fog{Mode: FogLinear, Color: V3{0.2, 0.3, 0.8}, Start: 2, End: 50},
+	}
+	for i := range scene.Triangles {
+		scene.Triangles[i].Normals = [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+	}
+
+	render := func(deferred bool) *image.NRGBA {
+		r := NewRenderer()
+		r.Samples = 4
+		r.Deferred = deferred
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		if err := r.Render(img, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	forward, deferred := render(false), render(true)
+	for i := range forward.Pix {
+		if forward.Pix[i] != deferred.Pix[i] {
+			t.Fatalf("pixel %d, %d: forward = %v, deferred = %v", i/4%64, i/4/64,
+				forward.NRGBAAt(i/4%64, i/4/64), deferred.NRGBAAt(i/4%64, i/4/64))
+		}
+	}
+}
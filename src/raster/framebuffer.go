@@ -0,0 +1,69 @@
+package raster
+
+import (
+	"image"
+
+	. "matrix"
+)
+
+// standard sample positions in 1/16ths of a pixel relative to the pixel center
+// https://msdn.microsoft.com/en-us/library/windows/desktop/ff476218(v=vs.85).aspx
+var samplePatterns = map[int][][2]int64{
+	1: {{0, 0}},
+	2: {{4, 4}, {-4, -4}},
+	4: {{-2, -6}, {6, -2}, {-6, 2}, {2, 6}},
+	8: {{1, -3}, {-1, 3}, {5, 1}, {-3, -5}, {-5, 5}, {-7, -1}, {3, 7}, {7, -7}},
+}
+
+// framebuffer stores color and depth for every sample of every pixel,
+// samples of a pixel are stored next to each other
+type framebuffer struct {
+	width   int
+	height  int
+	samples int
+	color   []V4
+	depth   []float32
+	// fragments shaded per pixel, only counted for the overdraw debug view
+	overdraw []int32
+	// the G-buffer of deferred shading, a surface per sample
+	surfaces []Surface
+	written  []bool
+}
+
+func (fb *framebuffer) resize(width, height, samples int) {
+	fb.width = width
+	fb.height = height
+	fb.samples = samples
+	n := width * height * samples
+	if len(fb.color) != n {
+		fb.color = make([]V4, n)
+		fb.depth = make([]float32, n)
+	}
+}
+
+func (fb *framebuffer) clear(c V4) {
+	for i := range fb.color {
+		fb.color[i] = c
+		fb.depth[i] = 1
+	}
+}
+
+// resolve averages the linear samples of each pixel, applies the exposure and tonemap,
+// and encodes the result as sRGB into the image
+func (fb *framebuffer) resolve(img *image.NRGBA, exposure float32, tonemap Tonemap) {
+	scale := 1 / float32(fb.samples)
+	for py := 0; py < fb.height; py++ {
+		for px := 0; px < fb.width; px++ {
+			offset := (py*fb.width + px) * fb.samples
+			sum := V4{}
+			for _, c := range fb.color[offset : offset+fb.samples] {
+				sum = sum.Add(c)
+			}
+			c := sum.MultiplyScalar(scale)
+			alpha := c[3]
+			c = tonemap.apply(c.MultiplyScalar(exposure))
+			c = V4{linearToSRGB(c[0]), linearToSRGB(c[1]), linearToSRGB(c[2]), alpha}
+			img.SetNRGBA(px, py, toNRGBA(c))
+		}
+	}
+}
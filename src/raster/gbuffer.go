@@ -0,0 +1,129 @@
+package raster
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	. "matrix"
+)
+
+// GBufferChannel selects the data of the G-buffer that ExportGBuffer draws
+type GBufferChannel int
+
+const (
+	// view space positions, each axis scaled to the range of the visible surfaces
+	GBufferPosition GBufferChannel = iota
+	// view space normals mapped from -1..1 to 0..1
+	GBufferNormal
+	// diffuse colors
+	GBufferAlbedo
+	// a different color for every material
+	GBufferMaterial
+)
+
+// resizeGBuffer makes room for a surface per sample and marks all of them empty
+func (fb *framebuffer) resizeGBuffer() {
+	n := fb.width * fb.height * fb.samples
+	if len(fb.surfaces) != n {
+		fb.surfaces = make([]Surface, n)
+		fb.written = make([]bool, n)
+	}
+	for i := range fb.written {
+		fb.written[i] = false
+	}
+}
+
+// lightGBuffer runs the lighting of the deferred shader on the G-buffer and writes the colors,
+// samples of a pixel covered by the same fragment share the same surface and are only lit once
+func (r *Renderer) lightGBuffer() {
+	fb := &r.fb
+	pattern := samplePatterns[fb.samples]
+	r.parallelRows(fb.height, func(y int) {
+		var lit V4
+		for i := y * fb.width * fb.samples; i < (y+1)*fb.width*fb.samples; i++ {
+			if !fb.written[i] {
+				continue
+			}
+			if len(r.ao) > 0 {
+				fb.surfaces[i].AmbientOcclusion = r.ao[i/fb.samples]
+			}
+			if i%fb.samples == 0 || !fb.written[i-1] || fb.surfaces[i] != fb.surfaces[i-1] {
+				lit = r.deferred.LightSurface(&r.uniforms, &fb.surfaces[i])
+			}
+			fb.color[i] = lit
+			if r.fog != nil {
+				x := i / fb.samples % fb.width
+				fb.color[i] = r.fog.applySample(lit, x, y, pattern[i%fb.samples], fb.depth[i])
+			}
+		}
+	})
+}
+
+// ExportGBuffer draws a channel of the G-buffer of the last frame into an image of the same size,
+// using the first covered sample of every pixel, pixels without a surface are transparent
+func (r *Renderer) ExportGBuffer(img *image.NRGBA, channel GBufferChannel) error {
+	fb := &r.fb
+	if r.deferred == nil {
+		return errors.New("the last frame was not rendered with deferred shading")
+	}
+	if img.Bounds().Dx() != fb.width || img.Bounds().Dy() != fb.height {
+		return errors.New("image size doesn't match the G-buffer")
+	}
+
+	surface := func(px, py int) *Surface {
+		base := (py*fb.width + px) * fb.samples
+		for s := 0; s < fb.samples; s++ {
+			if fb.written[base+s] {
+				return &fb.surfaces[base+s]
+			}
+		}
+		return nil
+	}
+
+	// positions are scaled to the bounds of the visible surfaces
+	inf := float32(math.Inf(1))
+	lo, hi := V3{inf, inf, inf}, V3{-inf, -inf, -inf}
+	if channel == GBufferPosition {
+		for i := range fb.surfaces {
+			if fb.written[i] {
+				lo = lo.Minimum(fb.surfaces[i].Position)
+				hi = hi.Maximum(fb.surfaces[i].Position)
+			}
+		}
+	}
+	materials := map[*Material]int{}
+
+	for py := 0; py < fb.height; py++ {
+		for px := 0; px < fb.width; px++ {
+			s := surface(px, py)
+			if s == nil {
+				img.SetNRGBA(px, py, toNRGBA(V4{}))
+				continue
+			}
+
+			var c V4
+			switch channel {
+			case GBufferPosition:
+				size := hi.Subtract(lo).Maximum(V3{1e-6, 1e-6, 1e-6})
+				p := s.Position.Subtract(lo).Divide(size)
+				c = V4{p[0], p[1], p[2], 1}
+			case GBufferNormal:
+				n := s.Normal
+				c = V4{n[0]*0.5 + 0.5, n[1]*0.5 + 0.5, n[2]*0.5 + 0.5, 1}
+			case GBufferAlbedo:
+				a := s.Albedo
+				c = V4{linearToSRGB(clamp(a[0])), linearToSRGB(clamp(a[1])), linearToSRGB(clamp(a[2])), 1}
+			case GBufferMaterial:
+				id, ok := materials[s.Material]
+				if !ok {
+					id = len(materials)
+					materials[s.Material] = id
+				}
+				c = idColor(id, 1)
+			}
+			img.SetNRGBA(px, py, toNRGBA(c))
+		}
+	}
+	return nil
+}
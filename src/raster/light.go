@@ -0,0 +1,158 @@
+package raster
+
+import (
+	"math"
+
+	. "matrix"
+)
+
+type LightType int
+
+const (
+	// infinitely far away, lights everything from the same direction
+	LightDirectional LightType = iota
+	// shines in every direction from a position
+	LightPoint
+	// shines in a cone from a position
+	LightSpot
+)
+
+// Light is a light source, positions and directions are in world space
+type Light struct {
+	Type LightType
+	// ignored by directional lights
+	Position V4
+	// direction the light shines in, ignored by point lights
+	Direction V4
+	Color     V3
+	Intensity float32
+
+	// distance attenuation of point and spot lights is 1 / (Constant + Linear*d + Quadratic*d*d)
+	Constant  float32
+	Linear    float32
+	Quadratic float32
+
+	// angles in radians from the direction of a spot light, the light fades out between them
+	InnerCone float32
+	OuterCone float32
+
+	// render a shadow map for the light, only directional and spot lights cast shadows
+	CastShadows bool
+	// width and height of the shadow map in pixels
+	ShadowMapSize int
+	// distance along the light's direction subtracted from the depth before comparing with the shadow map,
+	// to avoid self shadowing
+	ShadowBias float32
+	// radius in texels of the percentage closer filter, 0 gives hard shadows
+	ShadowFilter int
+
+	shadow *shadowMap
+}
+
+func NewDirectionalLight(direction V4, color V3) Light {
+	return Light{
+		Type:          LightDirectional,
+		Direction:     direction,
+		Color:         color,
+		Intensity:     1,
+		ShadowMapSize: defaultShadowMapSize,
+		ShadowBias:    defaultShadowBias,
+		ShadowFilter:  1,
+	}
+}
+
+func NewPointLight(position V4, color V3) Light {
+	return Light{
+		Type:      LightPoint,
+		Position:  position,
+		Color:     color,
+		Intensity: 1,
+		Constant:  1,
+	}
+}
+
+func NewSpotLight(position, direction V4, innerCone, outerCone float32, color V3) Light {
+	return Light{
+		Type:      LightSpot,
+		Position:  position,
+		Direction: direction,
+		Color:     color,
+		Intensity: 1,
+		Constant:  1,
+		InnerCone: innerCone,
+		OuterCone: outerCone,
+
+		ShadowMapSize: defaultShadowMapSize,
+		ShadowBias:    defaultShadowBias,
+		ShadowFilter:  1,
+	}
+}
+
+// transform returns the light with its position and direction multiplied by m, without its shadow map
+func (l Light) transform(m M4) Light {
+	pos := l.Position
+	dir := l.Direction
+	l.Position = m.MultiplyV4(V4{pos[0], pos[1], pos[2], 1})
+	l.Direction = m.MultiplyV4(V4{dir[0], dir[1], dir[2], 0})
+	l.shadow = nil
+	return l
+}
+
+// illuminate returns the normalized direction from position towards the light
+// and the light color that arrives there, normal offsets the shadow lookup,
+// a light without a direction to the position gives no light
+func (l *Light) illuminate(position, normal V3) (V3, V3) {
+	radiance := l.Color.MultiplyScalar(l.Intensity)
+	if l.Type == LightDirectional {
+		direction := V3{-l.Direction[0], -l.Direction[1], -l.Direction[2]}
+		length := direction.Length()
+		if length == 0 {
+			return V3{}, V3{}
+		}
+		direction = direction.DivideScalar(length)
+		if l.shadow != nil {
+			radiance = radiance.MultiplyScalar(l.shadow.visibility(position, normal, direction))
+		}
+		return direction, radiance
+	}
+
+	toLight := V3{l.Position[0], l.Position[1], l.Position[2]}.Subtract(position)
+	distance := toLight.Length()
+	if distance == 0 {
+		// a light on the surface has no direction to shine from
+		return V3{}, V3{}
+	}
+	direction := toLight.DivideScalar(distance)
+
+	attenuation := l.Constant + l.Linear*distance + l.Quadratic*distance*distance
+	if attenuation > 0 {
+		radiance = radiance.DivideScalar(attenuation)
+	}
+
+	if l.Type == LightSpot {
+		spot := V3{l.Direction[0], l.Direction[1], l.Direction[2]}
+		if spot.Length() == 0 {
+			return V3{}, V3{}
+		}
+		cos := -direction.DotProduct(spot.Normalize())
+		inner := float32(math.Cos(float64(l.InnerCone)))
+		outer := float32(math.Cos(float64(l.OuterCone)))
+		radiance = radiance.MultiplyScalar(smoothstep(outer, inner, cos))
+	}
+	if l.shadow != nil {
+		radiance = radiance.MultiplyScalar(l.shadow.visibility(position, normal, direction))
+	}
+
+	return direction, radiance
+}
+
+func smoothstep(edge0, edge1, x float32) float32 {
+	if edge0 >= edge1 {
+		if x < edge0 {
+			return 0
+		}
+		return 1
+	}
+	t := clamp((x - edge0) / (edge1 - edge0))
+	return t * t * (3 - 2*t)
+}
@@ -0,0 +1,225 @@
+package raster
+
+import (
+	"math"
+
+	. "matrix"
+)
+
+// lines are pulled slightly towards the camera so that wireframes win the depth test against their own triangles
+const lineDepthBias = 1e-4
+
+type PolygonMode int
+
+const (
+	// shade the inside of triangles
+	PolygonFill PolygonMode = iota
+	// only draw the edges of triangles
+	PolygonLine
+	// draw the edges on top of the shaded triangles
+	PolygonFillAndLine
+)
+
+// lineSegment is a clipped line in window coordinates
+type lineSegment struct {
+	x0, y0, z0 float32
+	x1, y1, z1 float32
+	color      V4
+}
+
+// appendLine clips a line given in clip space to the view frustum and queues it for drawing
+func (r *Renderer) appendLine(a, b V4, color V4) {
+	a, b, ok := clipLine(a, b, frustumPlanes)
+	if !ok {
+		return
+	}
+
+	fwidth := float32(r.fb.width)
+	fheight := float32(r.fb.height)
+	a = a.MultiplyScalar(1 / a[3])
+	b = b.MultiplyScalar(1 / b[3])
+	r.lines = append(r.lines, lineSegment{
+		x0: (a[0] + 1) / 2 * fwidth, y0: (1 - a[1]) / 2 * fheight, z0: a[2],
+		x1: (b[0] + 1) / 2 * fwidth, y1: (1 - b[1]) / 2 * fheight, z1: b[2],
+		color: color,
+	})
+}
+
+// appendEdges queues the edges of a triangle, edges shared with earlier triangles are only queued once
+func (r *Renderer) appendEdges(d *ProcessedTriangle, seen map[[2]V4]bool) {
+	for i := range d.Vertices {
+		a, b := d.Vertices[i], d.Vertices[(i+1)%3]
+		if lessV4(b, a) {
+			a, b = b, a
+		}
+		if seen[[2]V4{a, b}] {
+			continue
+		}
+		seen[[2]V4{a, b}] = true
+		r.appendLine(a, b, r.WireframeColor)
+	}
+}
+
+func lessV4(a, b V4) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// drawLines rasterizes the queued lines with depth testing
+func (r *Renderer) drawLines() {
+	for i := range r.lines {
+		if r.SmoothLines {
+			r.drawLineWu(&r.lines[i])
+		} else {
+			r.drawLineBresenham(&r.lines[i])
+		}
+	}
+	r.lines = r.lines[:0]
+}
+
+// http://members.chello.at/~easyfilter/bresenham.html
+func (r *Renderer) drawLineBresenham(l *lineSegment) {
+	x0, y0 := int(math.Floor(float64(l.x0))), int(math.Floor(float64(l.y0)))
+	x1, y1 := int(math.Floor(float64(l.x1))), int(math.Floor(float64(l.y1)))
+
+	dx := x1 - x0
+	sx := 1
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	dy := y0 - y1
+	sy := 1
+	if dy > 0 {
+		dy, sy = -dy, -1
+	}
+	steps := maxInt(dx, -dy)
+
+	e := dx + dy
+	for i := 0; ; i++ {
+		t := float32(0)
+		if steps > 0 {
+			t = float32(i) / float32(steps)
+		}
+		r.plot(x0, y0, l.z0+(l.z1-l.z0)*t, l.color, 1)
+
+		if x0 == x1 && y0 == y1 {
+			break
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// drawLineWu draws an anti-aliased line by splitting the coverage between the two pixels closest to it
+// https://en.wikipedia.org/wiki/Xiaolin_Wu%27s_line_algorithm
+func (r *Renderer) drawLineWu(l *lineSegment) {
+	// integer coordinates are pixel centers
+	x0, y0, z0 := l.x0-0.5, l.y0-0.5, l.z0
+	x1, y1, z1 := l.x1-0.5, l.y1-0.5, l.z1
+
+	steep := abs(y1-y0) > abs(x1-x0)
+	if steep {
+		x0, y0 = y0, x0
+		x1, y1 = y1, x1
+	}
+	if x0 > x1 {
+		x0, x1 = x1, x0
+		y0, y1 = y1, y0
+		z0, z1 = z1, z0
+	}
+
+	plot := func(x, y int, coverage float32) {
+		// depth along the major axis
+		t := float32(0)
+		if x1 > x0 {
+			t = clamp((float32(x) - x0) / (x1 - x0))
+		}
+		z := z0 + (z1-z0)*t
+		if steep {
+			r.plot(y, x, z, l.color, coverage)
+		} else {
+			r.plot(x, y, z, l.color, coverage)
+		}
+	}
+
+	dx := x1 - x0
+	gradient := float32(1)
+	if dx > 0 {
+		gradient = (y1 - y0) / dx
+	}
+
+	// first endpoint
+	xEnd := round(x0)
+	yEnd := y0 + gradient*(xEnd-x0)
+	xGap := 1 - fpart(x0+0.5)
+	xStart := int(xEnd)
+	plot(xStart, int(ipart(yEnd)), (1-fpart(yEnd))*xGap)
+	plot(xStart, int(ipart(yEnd))+1, fpart(yEnd)*xGap)
+	intery := yEnd + gradient
+
+	// second endpoint
+	xEnd = round(x1)
+	yEnd = y1 + gradient*(xEnd-x1)
+	xGap = fpart(x1 + 0.5)
+	xStop := int(xEnd)
+	if xStop != xStart {
+		plot(xStop, int(ipart(yEnd)), (1-fpart(yEnd))*xGap)
+		plot(xStop, int(ipart(yEnd))+1, fpart(yEnd)*xGap)
+	}
+
+	for x := xStart + 1; x < xStop; x++ {
+		plot(x, int(ipart(intery)), 1-fpart(intery))
+		plot(x, int(ipart(intery))+1, fpart(intery))
+		intery += gradient
+	}
+}
+
+// plot depth tests every sample of a pixel, fully covered opaque pixels replace the color and depth,
+// partially covered ones are blended over without writing depth
+func (r *Renderer) plot(x, y int, z float32, c V4, coverage float32) {
+	fb := &r.fb
+	if x < 0 || y < 0 || x >= fb.width || y >= fb.height || coverage <= 0 {
+		return
+	}
+
+	if r.fog != nil {
+		c = r.fog.apply(c, float32(x)+0.5, float32(y)+0.5, z)
+	}
+	z -= lineDepthBias
+	alpha := c[3] * coverage
+	base := (y*fb.width + x) * fb.samples
+	for s := 0; s < fb.samples; s++ {
+		if z < -1 || z > fb.depth[base+s] {
+			continue
+		}
+		if alpha >= 1 {
+			fb.color[base+s] = c
+			fb.depth[base+s] = z
+		} else {
+			fb.color[base+s] = BlendOver.blend(V4{c[0], c[1], c[2], alpha}, fb.color[base+s])
+		}
+	}
+}
+
+func ipart(f float32) float32 {
+	return float32(math.Floor(float64(f)))
+}
+
+func fpart(f float32) float32 {
+	return f - ipart(f)
+}
+
+func round(f float32) float32 {
+	return ipart(f + 0.5)
+}
@@ -0,0 +1,121 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	. "matrix"
+)
+
+func TestClipLine(t *testing.T) {
+	a, b, ok := clipLine(V4{-2, 0, 0, 1}, V4{0.5, 0, 0, 1}, frustumPlanes)
+	if !ok || !closeV4(a, V4{-1, 0, 0, 1}) || !closeV4(b, V4{0.5, 0, 0, 1}) {
+		t.Errorf("clipLine = %v, %v, %v", a, b, ok)
+	}
+	if _, _, ok := clipLine(V4{2, 0, 0, 1}, V4{3, 1, 0, 1}, frustumPlanes); ok {
+		t.Error("line outside the frustum wasn't rejected")
+	}
+}
+
+func lineRenderer(width, height int) *Renderer {
+	r := NewRenderer()
+	r.fb.resize(width, height, 1)
+	r.fb.clear(V4{0, 0, 0, 1})
+	return r
+}
+
+func TestDrawLineBresenham(t *testing.T) {
+	r := lineRenderer(8, 8)
+	// the far half of the line is behind the depth buffer
+	for x := 4; x < 8; x++ {
+		r.fb.depth[3*8+x] = -0.5
+	}
+	r.lines = []lineSegment{{x0: 0.5, y0: 3.5, z0: 0, x1: 7.5, y1: 3.5, z1: 0, color: V4{1, 1, 1, 1}}}
+	r.drawLines()
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			expected := V4{0, 0, 0, 1}
+			if y == 3 && x < 4 {
+				expected = V4{1, 1, 1, 1}
+			}
+			if c := r.fb.color[y*8+x]; c != expected {
+				t.Errorf("pixel (%d, %d) = %v, expected %v", x, y, c, expected)
+			}
+		}
+	}
+
+	// a diagonal line has one pixel per column
+	r = lineRenderer(8, 8)
+	r.lines = []lineSegment{{x0: 0.5, y0: 0.5, x1: 7.5, y1: 3.5, color: V4{1, 1, 1, 1}}}
+	r.drawLines()
+	for x := 0; x < 8; x++ {
+		n := 0
+		for y := 0; y < 8; y++ {
+			if r.fb.color[y*8+x][0] == 1 {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("column %d has %d pixels", x, n)
+		}
+	}
+}
+
+func TestDrawLineWu(t *testing.T) {
+	r := lineRenderer(8, 8)
+	r.SmoothLines = true
+	r.lines = []lineSegment{{x0: 0.5, y0: 1.5, x1: 7.5, y1: 4.5, color: V4{1, 1, 1, 1}}}
+	r.drawLines()
+
+	// the coverage of every column adds up to one pixel
+	for x := 0; x < 8; x++ {
+		sum := float32(0)
+		for y := 0; y < 8; y++ {
+			sum += r.fb.color[y*8+x][0]
+		}
+		expected := float32(1)
+		if x == 0 || x == 7 {
+			// the endpoints cover half a pixel
+			expected = 0.5
+		}
+		if abs(sum-expected) > 1e-3 {
+			t.Errorf("column %d coverage = %f, expected %f", x, sum, expected)
+		}
+	}
+}
+
+func TestRenderLines(t *testing.T) {
+	scene := triangleScene()
+	scene.Lines = []Line{{Vertices: [2]V4{{-3, 0.5, 1}, {3, 0.5, 1}}, Color: V4{1, 0, 0, 1}}}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	r := NewRenderer()
+	r.PolygonMode = PolygonLine
+	r.WireframeColor = V4{0, 1, 0, 1}
+	if err := r.Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+
+	red, green := 0, 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+		switch c {
+		case color.NRGBA{255, 0, 0, 255}:
+			red++
+		case color.NRGBA{0, 255, 0, 255}:
+			green++
+		case background:
+		default:
+			t.Fatalf("unexpected color %v", c)
+		}
+	}
+	// the line spans the width, and the wireframe has no fill
+	if red < 60 {
+		t.Errorf("%d line pixels", red)
+	}
+	if green == 0 || green > 100 {
+		t.Errorf("%d wireframe pixels", green)
+	}
+}
@@ -0,0 +1,67 @@
+package raster
+
+import (
+	"math"
+
+	. "matrix"
+)
+
+type PointShape int
+
+const (
+	PointSquare PointShape = iota
+	PointRound
+)
+
+// drawPoint draws a point given in clip space as a sprite of r.PointSize pixels, facing the camera
+// coverage is tested for every sample, so round points are anti-aliased when multisampling
+func (r *Renderer) drawPoint(position V4, c V4) {
+	if outcode(position) != 0 {
+		return
+	}
+
+	fb := &r.fb
+	ndc := position.MultiplyScalar(1 / position[3])
+	x := (ndc[0] + 1) / 2 * float32(fb.width)
+	y := (1 - ndc[1]) / 2 * float32(fb.height)
+	z := ndc[2]
+
+	size := r.PointSize
+	if size <= 0 {
+		size = 1
+	}
+	radius := size / 2
+
+	if r.fog != nil {
+		c = r.fog.apply(c, x, y, z)
+	}
+
+	pattern := samplePatterns[fb.samples]
+	minPx := maxInt(0, int(math.Floor(float64(x-radius))))
+	minPy := maxInt(0, int(math.Floor(float64(y-radius))))
+	maxPx := minInt(fb.width-1, int(math.Floor(float64(x+radius))))
+	maxPy := minInt(fb.height-1, int(math.Floor(float64(y+radius))))
+
+	for py := minPy; py <= maxPy; py++ {
+		for px := minPx; px <= maxPx; px++ {
+			base := (py*fb.width + px) * fb.samples
+			for s, offset := range pattern {
+				// distance from the point to the sample
+				dx := float32(px) + 0.5 + float32(offset[0])/16 - x
+				dy := float32(py) + 0.5 + float32(offset[1])/16 - y
+
+				var inside bool
+				if r.PointShape == PointRound {
+					inside = dx*dx+dy*dy <= radius*radius
+				} else {
+					inside = dx >= -radius && dx < radius && dy >= -radius && dy < radius
+				}
+
+				if inside && z <= fb.depth[base+s] {
+					fb.color[base+s] = c
+					fb.depth[base+s] = z
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	. "matrix"
+)
+
+// window coordinates are snapped to a fixed-point grid with this many bits of sub-pixel precision
+const (
+	subPixelBits = 8
+	subPixelOne  = 1 << subPixelBits
+	subPixelHalf = subPixelOne / 2
+)
+
+func snap(f float32) int64 {
+	return int64(math.Floor(float64(f)*subPixelOne + 0.5))
+}
+
+// edge function for the directed edge (x0, y0) -> (x1, y1), stepped incrementally across 2x2 pixel quads
+// http://www.cs.drexel.edu/~david/Classes/Papers/comp175-06-pineda.pdf
+type edge struct {
+	stepX int64
+	stepY int64
+	row   int64
+	value int64
+	bias  int64
+	// offsets from the value at the pixel center to the value at each sample
+	samples [8]int64
+}
+
+func newEdge(x0, y0, x1, y1, px, py int64) edge {
+	e := edge{
+		stepX: (y1 - y0) * subPixelOne,
+		stepY: (x0 - x1) * subPixelOne,
+	}
+
+	// evaluate at the center of the first pixel
+	e.row = (y1-y0)*(px-x0) - (x1-x0)*(py-y0)
+	e.value = e.row
+
+	// top-left fill rule, pixels exactly on an edge belong to the triangle only if the edge is a top or a left edge
+	// https://msdn.microsoft.com/en-us/library/windows/desktop/cc627092(v=vs.85).aspx#Triangle
+	dx := x1 - x0
+	dy := y1 - y0
+	if !(dy > 0 || (dy == 0 && dx < 0)) {
+		e.bias = 1
+	}
+
+	return e
+}
+
+func (e *edge) setSamples(pattern [][2]int64) {
+	for i, s := range pattern {
+		dx := s[0] * subPixelOne / 16
+		dy := s[1] * subPixelOne / 16
+		e.samples[i] = (e.stepX*dx + e.stepY*dy) >> subPixelBits
+	}
+}
+
+// pixel returns the value at the center of pixel q of the current quad, numbered left to right, top to bottom
+func (e *edge) pixel(q int) int64 {
+	return e.value + int64(q&1)*e.stepX + int64(q>>1)*e.stepY
+}
+
+func (e *edge) inside(value int64) bool {
+	return value >= e.bias
+}
+
+func (e *edge) nextQuad() {
+	e.value += 2 * e.stepX
+}
+
+func (e *edge) nextQuadRow() {
+	e.row += 2 * e.stepY
+	e.value = e.row
+}
+
+// a triangle set up for rasterization in window coordinates
+type primitive struct {
+	w        [3]float32
+	depth    [3]float32
+	varyings [3][]float32
+	x        [3]int64
+	y        [3]int64
+	area     int64
+	bounds   image.Rectangle
+	triangle *Triangle
+	// index of the triangle in the scene
+	id int
+	// nil for opaque primitives, which write depth and overwrite the color
+	blend       *BlendState
+	frontFacing bool
+}
+
+// setupTriangle projects a clipped triangle to the window, returning false if it can't produce any pixels
+// the vertices of back facing triangles are reordered so that the rasterizer always sees a positive area
+func setupTriangle(va, vb, vc clipVertex, triangle *Triangle, width, height int, frontFace Winding) (primitive, bool) {
+	fwidth := float32(width)
+	fheight := float32(height)
+	p := primitive{triangle: triangle}
+
+	for i, v := range [3]clipVertex{va, vb, vc} {
+		ndc := v.Position.MultiplyScalar(1.0 / v.Position[3])
+
+		// convert to fixed-point window coordinates, the origin is at the top-left so that the image can be written directly
+		p.x[i] = snap((ndc[0] + 1) / 2 * fwidth)
+		p.y[i] = snap((1 - ndc[1]) / 2 * fheight)
+		p.depth[i] = ndc[2]
+		p.w[i] = v.Position[3]
+		p.varyings[i] = v.Varyings
+	}
+
+	ax, ay := p.x[0], p.y[0]
+	bx, by := p.x[1], p.y[1]
+	cx, cy := p.x[2], p.y[2]
+
+	// the area is positive for CCW triangles
+	// https://www.opengl.org/registry/doc/glspec44.core.pdf p.426
+	p.area = (by-ay)*(cx-ax) - (bx-ax)*(cy-ay)
+	if p.area == 0 {
+		return p, false
+	}
+	p.frontFacing = (p.area > 0) == (frontFace == CounterClockwise)
+	if p.area < 0 {
+		p.area = -p.area
+		p.x[1], p.x[2] = p.x[2], p.x[1]
+		p.y[1], p.y[2] = p.y[2], p.y[1]
+		p.depth[1], p.depth[2] = p.depth[2], p.depth[1]
+		p.w[1], p.w[2] = p.w[2], p.w[1]
+		p.varyings[1], p.varyings[2] = p.varyings[2], p.varyings[1]
+	}
+
+	// create bounding boxes for triangles
+	// it's really slow without bounding boxes
+	// samples can be anywhere inside a pixel, so every pixel the box touches is included
+	minPx := int(min64(ax, min64(bx, cx)) >> subPixelBits)
+	minPy := int(min64(ay, min64(by, cy)) >> subPixelBits)
+	maxPx := int(max64(ax, max64(bx, cx)) >> subPixelBits)
+	maxPy := int(max64(ay, max64(by, cy)) >> subPixelBits)
+	p.bounds = image.Rect(minPx, minPy, maxPx+1, maxPy+1).Intersect(image.Rect(0, 0, width, height))
+
+	return p, !p.bounds.Empty()
+}
+
+// drawTriangle rasterizes the part of the primitive that falls inside bounds
+// pixels are visited in 2x2 quads so that the fragment shader gets derivatives of the varyings,
+// pixels of a quad that aren't covered are still interpolated but not shaded
+// coverage and depth are tested for every sample, but the fragment shader runs once per pixel
+// scratch holds the interpolated values and derivatives, 6 floats per varying
+func (r *Renderer) drawTriangle(p *primitive, bounds image.Rectangle, scratch []float32) {
+	bounds = bounds.Intersect(p.bounds)
+	if bounds.Empty() {
+		return
+	}
+	minPx, minPy := bounds.Min.X, bounds.Min.Y
+	maxPx, maxPy := bounds.Max.X-1, bounds.Max.Y-1
+
+	fb := &r.fb
+	pattern := samplePatterns[fb.samples]
+	u := &r.uniforms
+	_, shader := r.shaders()
+
+	ax, ay := p.x[0], p.y[0]
+	bx, by := p.x[1], p.y[1]
+	cx, cy := p.x[2], p.y[2]
+
+	interpA := p.varyings[0]
+	interpB := p.varyings[1]
+	interpC := p.varyings[2]
+	n := len(interpA)
+	interps := scratch[:4*n]
+
+	f := Fragment{
+		DDX:         scratch[4*n : 5*n],
+		DDY:         scratch[5*n : 6*n],
+		FrontFacing: p.frontFacing,
+		Triangle:    p.triangle,
+		PrimitiveID: p.id,
+	}
+
+	// quads start at even pixel coordinates, so neighbouring triangles agree on them
+	quadX := minPx &^ 1
+	quadY := minPy &^ 1
+
+	// each edge function is the (doubled) area of the sub-triangle opposite a vertex, which gives the barycentric coordinates
+	startX := int64(quadX)<<subPixelBits + subPixelHalf
+	startY := int64(quadY)<<subPixelBits + subPixelHalf
+	e0 := newEdge(bx, by, cx, cy, startX, startY)
+	e1 := newEdge(cx, cy, ax, ay, startX, startY)
+	e2 := newEdge(ax, ay, bx, by, startX, startY)
+	e0.setSamples(pattern)
+	e1.setSamples(pattern)
+	e2.setSamples(pattern)
+	farea := float32(p.area)
+
+	// alpha testing applies to opaque primitives with cutout materials
+	var cutoff float32
+	if m := p.triangle.Material; m != nil && p.blend == nil {
+		cutoff = m.AlphaCutoff
+	}
+	alphaToCoverage := r.AlphaToCoverage && fb.samples > 1
+	// opaque primitives only write their surfaces when shading is deferred
+	deferred := r.deferred != nil && p.blend == nil
+	var surface Surface
+
+	var masks [4]int
+	var sampleDepth [4][8]float32
+
+	for qy := quadY; qy <= maxPy; qy += 2 {
+		for qx := quadX; qx <= maxPx; qx += 2 {
+			// find the samples of each pixel that are covered and pass the depth test
+			live := false
+			for q := range masks {
+				masks[q] = 0
+				px := qx + q&1
+				py := qy + q>>1
+				if px < minPx || px > maxPx || py < minPy || py > maxPy {
+					continue
+				}
+
+				base := (py*fb.width + px) * fb.samples
+				v0, v1, v2 := e0.pixel(q), e1.pixel(q), e2.pixel(q)
+				for s := range pattern {
+					s0, s1, s2 := v0+e0.samples[s], v1+e1.samples[s], v2+e2.samples[s]
+					if e0.inside(s0) && e1.inside(s1) && e2.inside(s2) {
+						// calculate barycentric coordinates http://en.wikipedia.org/wiki/Barycentric_coordinate_system
+						ba := float32(s0) / farea
+						bb := float32(s1) / farea
+						bc := 1 - ba - bb
+
+						// https://www.opengl.org/registry/doc/glspec44.core.pdf p.427
+						depth := ba*p.depth[0] + bb*p.depth[1] + bc*p.depth[2]
+						if depth >= -1 && depth <= fb.depth[base+s] {
+							masks[q] |= 1 << uint(s)
+							sampleDepth[q][s] = depth
+						}
+					}
+				}
+				live = live || masks[q] != 0
+			}
+
+			if live {
+				// interpolate at the center of every pixel of the quad
+				for q := 0; q < 4; q++ {
+					ba := float32(e0.pixel(q)) / farea
+					bb := float32(e1.pixel(q)) / farea
+					bc := 1 - ba - bb
+
+					ia := ba / p.w[0]
+					ib := bb / p.w[1]
+					ic := bc / p.w[2]
+					idenom := ia + ib + ic
+
+					interp := interps[q*n : (q+1)*n]
+					for i := range interp {
+						interp[i] = (interpA[i]*ia + interpB[i]*ib + interpC[i]*ic) / idenom
+					}
+				}
+
+				for i := 0; i < n; i++ {
+					f.DDX[i] = interps[n+i] - interps[i]
+					f.DDY[i] = interps[2*n+i] - interps[i]
+				}
+
+				for q, mask := range masks {
+					if mask == 0 {
+						continue
+					}
+
+					ba := float32(e0.pixel(q)) / farea
+					bb := float32(e1.pixel(q)) / farea
+					bc := 1 - ba - bb
+
+					f.X = qx + q&1
+					f.Y = qy + q>>1
+					f.Depth = ba*p.depth[0] + bb*p.depth[1] + bc*p.depth[2]
+					f.Varyings = interps[q*n : (q+1)*n]
+					f.AmbientOcclusion = 0
+					if len(r.ao) > 0 {
+						f.AmbientOcclusion = r.ao[f.Y*fb.width+f.X]
+					}
+					var c V4
+					var ok bool
+					if deferred {
+						surface, ok = r.deferred.ShadeSurface(u, &f)
+						c[3] = surface.Alpha
+					} else {
+						c, ok = shader.ShadeFragment(u, &f)
+					}
+					if fb.overdraw != nil {
+						fb.overdraw[f.Y*fb.width+f.X]++
+					}
+					if !ok {
+						continue
+					}
+
+					if cutoff > 0 {
+						// cutouts are either fully covered or discarded, never blended
+						if alphaToCoverage {
+							mask &= alphaCoverage(c[3], fb.samples)
+						} else if c[3] < cutoff {
+							continue
+						}
+						c[3] = 1
+						surface.Alpha = 1
+					}
+					base := (f.Y*fb.width + f.X) * fb.samples
+					for s := range pattern {
+						if mask&(1<<uint(s)) == 0 {
+							continue
+						}
+						sc := c
+						if r.fog != nil && !deferred {
+							sc = r.fog.applySample(c, f.X, f.Y, pattern[s], sampleDepth[q][s])
+						}
+						if p.blend != nil {
+							fb.color[base+s] = p.blend.blend(sc, fb.color[base+s])
+						} else if deferred {
+							fb.depth[base+s] = sampleDepth[q][s]
+							fb.surfaces[base+s] = surface
+							fb.written[base+s] = true
+						} else {
+							fb.depth[base+s] = sampleDepth[q][s]
+							fb.color[base+s] = sc
+						}
+					}
+				}
+			}
+
+			e0.nextQuad()
+			e1.nextQuad()
+			e2.nextQuad()
+		}
+		e0.nextQuadRow()
+		e1.nextQuadRow()
+		e2.nextQuadRow()
+	}
+}
+
+// alphaCoverage returns a mask of the samples to keep for a fragment with the given alpha
+func alphaCoverage(alpha float32, samples int) int {
+	n := int(clamp(alpha)*float32(samples) + 0.5)
+	return 1<<uint(n) - 1
+}
+
+func toNRGBA(c V4) color.NRGBA {
+	return color.NRGBA{
+		uint8(clamp(c[0])*255 + 0.5),
+		uint8(clamp(c[1])*255 + 0.5),
+		uint8(clamp(c[2])*255 + 0.5),
+		uint8(clamp(c[3])*255 + 0.5),
+	}
+}
+
+func clamp(f float32) float32 {
+	return min(1, max(0, f))
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,41 +1,18 @@
-package main
+package raster
 
 import (
+	"errors"
+	"fmt"
 	"image"
 	"math"
-	"obj"
+	"runtime"
+	"sort"
 
 	"image/color"
 
 	. "matrix"
-
-	"github.com/go-gl/glfw/v3.1/glfw"
-)
-
-var (
-	triangles []Triangle
-)
-
-const (
-	interpCount = 5
 )
 
-type Triangle struct {
-	Vertices      [3]V4
-	TextureCoords [3]V4
-	Normals       [3]V4
-	Texture       *image.NRGBA
-}
-
-// find which side of a line a point is on using cross product
-func side(x0, y0, x1, y1, px, py float32) bool {
-	return (x1-x0)*(py-y0)-(y1-y0)*(px-x0) > 0
-}
-
-func clip(v V4) bool {
-	return v[0] < -1 || v[0] > 1 || v[1] < -1 || v[1] > 1 || v[2] < -1 || v[2] > 1
-}
-
 func HSVToRGB(h, s, v float64) color.NRGBA {
 	rgbToColor := func(r, g, b float64) color.NRGBA {
 		return color.NRGBA{uint8(r * 255), uint8(g * 255), uint8(b * 255), 255}
@@ -82,344 +59,330 @@ func max(a, b float32) float32 {
 	}
 }
 
-var cameraPosition = V4{0, 0, 5}
-var cameraYRotation float32 = 0.0
-var cameraXRotation float32 = 0.0
-
-var rotation float32 = 0.0
-
-func setup() error {
-	// textureFile, err := os.Open("data/cat_diff.tga")
-	// if err != nil {
-	// 	return err
-	// }
-	//
-	// textureImage, err := tga.Decode(textureFile)
-	// if err != nil {
-	// 	return err
-	// }
-
-	// textureFile, err := os.Open("data/square.png")
-	// if err != nil {
-	// 	return err
-	// }
-	//
-	// textureImage, err := png.Decode(textureFile)
-	// if err != nil {
-	// 	return err
-	// }
-	//
-	// texture = textureImage.(*image.NRGBA)
-
-	objPath := "data/cat.obj"
-	// objPath := "data/dabrovic-sponza/sponza.obj"
-
-	objects, err := obj.Load(objPath)
-	if err != nil {
-		return err
-	}
+type CullMode int
 
-	convertedTextures := map[image.Image]*image.NRGBA{nil: nil}
-	for _, obj := range objects {
-		src := obj.Material.MapKd
-		if src == nil {
-			continue
-		}
-		dst := image.NewNRGBA(src.Bounds())
+const (
+	CullBack CullMode = iota
+	CullFront
+	CullNone
+)
 
-		for x := 0; x < src.Bounds().Max.X; x++ {
-			for y := 0; y < src.Bounds().Max.Y; y++ {
-				oldColor := src.At(x, y)
-				newColor := dst.ColorModel().Convert(oldColor)
-				dst.Set(x, y, newColor)
-			}
-		}
+type Winding int
 
-		convertedTextures[src] = dst
-	}
+const (
+	CounterClockwise Winding = iota
+	Clockwise
+)
 
-	for _, obj := range objects {
-		for _, f := range obj.Faces {
-			normals := f.Normals[:]
-			if len(normals) == 0 {
-				// if normals are missing, fill them in
-				normal := f.Vertices[1].Subtract(f.Vertices[0]).CrossProduct(f.Vertices[2].Subtract(f.Vertices[0])).Normalize()
-				for range f.Vertices {
-					normals = append(normals, normal)
-				}
-			}
+type Renderer struct {
+	// clip against all six frustum planes instead of only the near plane
+	ClipFrustum bool
+	// size in pixels of the square screen tiles that are rasterized in parallel
+	TileSize int
+	// number of goroutines used for vertex processing and rasterization
+	Workers int
+	// samples per pixel for multisample anti-aliasing, one of 1, 2, 4 or 8
+	Samples int
+	// which triangles are discarded depending on the way they face
+	CullMode CullMode
+	// winding order of front facing triangles as seen on screen
+	FrontFace Winding
+	// light the back of triangles as if their normals were flipped, for open meshes seen from both sides
+	TwoSided bool
+	// whether triangles are shaded, drawn as wireframes or both
+	PolygonMode PolygonMode
+	// color of the wireframe edges
+	WireframeColor V4
+	// draw lines anti-aliased with Xiaolin Wu's algorithm instead of Bresenham's
+	SmoothLines bool
+	// width of points in pixels
+	PointSize  float32
+	PointShape PointShape
+	// with multisampling, cover a fraction of the samples of cutout fragments according to their alpha
+	// instead of testing it against the material's AlphaCutoff
+	AlphaToCoverage bool
+	// the framebuffer holds linear colors that are scaled by Exposure and mapped by Tonemap
+	// before being encoded as sRGB
+	Exposure float32
+	Tonemap  Tonemap
+	// shows depth, normals and other internal data instead of the shaded image
+	DebugView DebugView
+	// rasterize the surfaces of opaque triangles into a G-buffer and light every pixel once afterwards,
+	// instead of lighting every fragment that passes the depth test
+	// the fragment shader has to be a DeferredShader
+	Deferred bool
+	// screen-space ambient occlusion darkens the ambient light in creases and corners
+	SSAO bool
+	// distance in view space around a surface that is searched for occluders
+	SSAORadius float32
+	// how dark fully occluded surfaces get, 1 removes their ambient light
+	SSAOStrength float32
+
+	VertexShader   VertexShader
+	FragmentShader FragmentShader
+
+	fb        framebuffer
+	bins      [][]int32
+	processed []ProcessedTriangle
+	varyings  []float32
+	uniforms  Uniforms
+	lights    []Light
+	lines     []lineSegment
+	// skip everything that doesn't contribute to the depth of triangles
+	depthOnly bool
+
+	shadowRenderer *Renderer
+	shadowMaps     []shadowMap
+	// replaces the configured shaders while a debug view is drawn
+	debugShader *debugShader
+	// the fragment shader while drawing deferred, nil for forward shading
+	deferred DeferredShader
+
+	// depth prepass and results of SSAO, ao is empty when it's disabled or not computed yet
+	depthRenderer *Renderer
+	ao            []float32
+	aoBlur        []float32
+	aoPositions   []V4
+	// per pixel depth of the G-buffer
+	aoDepth []float32
+
+	// applied to the colors of the main pass, nil without fog
+	fog *fogState
+}
 
-			// generate indices to generate triangles from polygons
-			// https://www.siggraph.org/education/materials/HyperGraph/scanline/outprims/polygon1.htm
-			for i := 0; i < len(f.Vertices)-2; i++ {
-				triangle := Triangle{
-					Vertices:      [3]V4{f.Vertices[0], f.Vertices[i+1], f.Vertices[i+2]},
-					TextureCoords: [3]V4{f.TextureCoords[0], f.TextureCoords[i+1], f.TextureCoords[i+2]},
-					Normals:       [3]V4{normals[0], normals[i+1], normals[i+2]},
-					Texture:       convertedTextures[obj.Material.MapKd],
-				}
-				triangles = append(triangles, triangle)
-			}
-		}
+func NewRenderer() *Renderer {
+	shader := NewPhongShader()
+	return &Renderer{
+		TileSize:       defaultTileSize,
+		Workers:        runtime.NumCPU(),
+		Samples:        1,
+		WireframeColor: V4{1, 1, 1, 1},
+		PointSize:      1,
+		Exposure:       1,
+		SSAORadius:     defaultSSAORadius,
+		SSAOStrength:   defaultSSAOStrength,
+		VertexShader:   shader,
+		FragmentShader: shader,
 	}
-
-	return nil
 }
 
-var frame = 0
+func (r *Renderer) Render(img *image.NRGBA, scene *Scene, camera Camera) error {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	fwidth := float32(width)
+	fheight := float32(height)
 
-func render(img *image.NRGBA, elapsed float64) error {
-	size := img.Bounds().Max.X
-	fsize := float32(size)
+	if _, ok := samplePatterns[r.Samples]; !ok {
+		return fmt.Errorf("unsupported sample count: %d", r.Samples)
+	}
 
-	dx, dy := window.GetCursorPos()
-	window.SetCursorPos(0, 0)
-	cameraYRotation += float32(dx / 100)
-	cameraXRotation += float32(dy / 100)
+	projection := camera.Projection(fwidth / fheight)
+	view := camera.View()
 
-	if cameraXRotation > math.Pi/2 {
-		cameraXRotation = math.Pi / 2
-	}
-	if cameraXRotation < -math.Pi/2 {
-		cameraXRotation = -math.Pi / 2
+	if err := r.renderShadowMaps(scene, view); err != nil {
+		return err
 	}
-
-	delta := V4{}
-	if keys[glfw.KeyD] {
-		delta = V4{1, 0, 0, 0}
+	if err := r.renderAmbientOcclusion(scene, view, projection, width, height); err != nil {
+		return err
 	}
 
-	if keys[glfw.KeyA] {
-		delta = V4{-1, 0, 0, 0}
-	}
+	// depth buffer so that we can draw triangles in any order and they don't overlap incorrectly
+	r.fb.resize(width, height, r.Samples)
+	r.deferred = nil
+	if r.DebugView == DebugNone {
+		if r.Deferred {
+			shader, ok := r.FragmentShader.(DeferredShader)
+			if !ok {
+				return errors.New("fragment shader doesn't support deferred shading")
+			}
+			r.deferred = shader
+			r.fb.resizeGBuffer()
+		}
 
-	if keys[glfw.KeyW] {
-		delta = V4{0, 0, -1, 0}
-	}
+		gray := srgbToLinear(127.0 / 255)
+		background := V4{gray, gray, gray, 1}
+		if scene.Fog.enabled() {
+			inverseProjection, ok := projection.Inverse()
+			if !ok {
+				return errors.New("failed to invert projection")
+			}
+			inverseView, ok := view.Inverse()
+			if !ok {
+				return errors.New("failed to invert transform")
+			}
+			r.fog = &fogState{
+				fog:               scene.Fog,
+				inverseProjection: inverseProjection,
+				inverseView:       inverseView,
+				camera:            V3{camera.Position[0], camera.Position[1], camera.Position[2]},
+				width:             fwidth,
+				height:            fheight,
+			}
+			c := scene.Fog.Color
+			background = V4{c[0], c[1], c[2], 1}
+		}
 
-	if keys[glfw.KeyS] {
-		delta = V4{0, 0, 1, 0}
+		r.fb.clear(background)
+		err := r.draw(scene, view, projection)
+		r.fog = nil
+		if err != nil {
+			return err
+		}
+		r.fb.resolve(img, r.Exposure, r.Tonemap)
+		return nil
 	}
 
-	if keys[glfw.KeyE] {
-		rotation += 0.1
+	if r.DebugView == DebugAmbientOcclusion {
+		r.resolveAmbientOcclusion(img)
+		return nil
 	}
 
-	if keys[glfw.KeyQ] {
-		rotation -= 0.1
+	r.fb.clear(V4{0, 0, 0, 1})
+	r.debugShader = nil
+	r.fb.overdraw = nil
+	if r.DebugView == DebugOverdraw {
+		r.fb.overdraw = make([]int32, width*height)
+	} else {
+		r.debugShader = &debugShader{view: r.DebugView}
 	}
-
-	if delta != (V4{}) {
-		cameraPosition = cameraPosition.Add(IdentityM4.RotateY(-cameraYRotation).RotateX(-cameraXRotation).MultiplyV4(delta.MultiplyScalar(float32(10 * elapsed))))
+	err := r.draw(scene, view, projection)
+	r.debugShader = nil
+	if err != nil {
+		return err
 	}
+	r.fb.resolveDebug(img, r.DebugView, camera.Near, camera.Far)
+	r.fb.overdraw = nil
+	return nil
+}
 
-	// clear the image
-	for py := 0; py < size; py++ {
-		for px := 0; px < size; px++ {
-			offset := py*img.Stride + px*4
-			img.Pix[offset] = 127
-			img.Pix[offset+1] = 127
-			img.Pix[offset+2] = 127
-			img.Pix[offset+3] = 255
-		}
+// shaders returns the shaders of the current pass, debug views replace the configured ones
+func (r *Renderer) shaders() (VertexShader, FragmentShader) {
+	if r.debugShader != nil {
+		return r.debugShader, r.debugShader
 	}
+	return r.VertexShader, r.FragmentShader
+}
 
+// draw transforms the scene with the given matrices and rasterizes it into the framebuffer
+func (r *Renderer) draw(scene *Scene, view, projection M4) error {
 	// process the vertex data
-	projection := IdentityM4.ProjectPerspective(70.0/180.0*math.Pi, 1, 1, 150)
-	// projection := Identity.ProjectOrthographic(-5, 5, -5, 5, 5, 15)
-	pos := cameraPosition.Negate()
-	view := IdentityM4.RotateX(cameraXRotation).RotateY(cameraYRotation).Translate(V3{pos[0], pos[1], pos[2]})
-	if record {
-		rotation = float32(frame) / 100 * math.Pi
-		frame++
-	}
-	model := IdentityM4.Scale(V3{3, 3, 3}).RotateY(rotation).RotateX(rotation).Translate(V3{-0.1, -0.5, -0.5})
-	modelView := view.Multiply(model)
+	modelView := view.Multiply(scene.Model)
 	modelViewProjection := projection.Multiply(modelView)
 
 	normalTransform, ok := modelView.InverseTranspose()
 	if !ok {
-		panic("failed to invert transform")
-	}
-
-	type Datum struct {
-		Vertices      [3]V4
-		TextureCoords [3]V4
-		Normals       [3]V4
-		Texture       *image.NRGBA
-		Interps       [3][interpCount]float32
+		return errors.New("failed to invert transform")
 	}
 
-	data := make([]Datum, len(triangles))
-	for i, t := range triangles {
-		data[i].Vertices = t.Vertices
-		data[i].TextureCoords = t.TextureCoords
-		data[i].Normals = t.Normals
-		data[i].Texture = t.Texture
+	r.uniforms = Uniforms{
+		Model:               scene.Model,
+		View:                view,
+		Projection:          projection,
+		ModelView:           modelView,
+		ModelViewProjection: modelViewProjection,
+		Normal:              normalTransform,
+		Lights:              r.lights,
+		Ambient:             scene.Ambient,
+		TwoSided:            r.TwoSided,
 	}
 
-	for i, t := range data {
-		for i := 0; i < 3; i++ {
-			pos := t.Vertices[i]
-			position := V4{pos[0], pos[1], pos[2], 1}
-			t.Vertices[i] = modelViewProjection.MultiplyV4(position)
-
-			tex := t.TextureCoords[i]
-
-			norm := t.Normals[i]
-			normal := V4{norm[0], norm[1], norm[2], 0}
+	data := r.ProcessVertices(scene.Triangles, &r.uniforms)
 
-			// calculate color (interpolated across triangle)
-			eye4 := normalTransform.MultiplyV4(normal)
-			eye := V4{eye4[0], eye4[1], eye4[2], 0}.Normalize()
-			// this is the light direction, not position
-			light := view.MultiplyV4(V4{1, 1, 1, 0})
-			dotProduct := max(0, eye.DotProduct(light.Normalize()))
-			diffuseColor := V3{0.4, 0.4, 1}
-			c := diffuseColor.MultiplyScalar(dotProduct)
-
-			t.Interps[i] = [interpCount]float32{c[0], c[1], c[2], tex[0], tex[1]}
-		}
-		data[i] = t
+	planes := guardBandPlanes
+	if r.ClipFrustum {
+		planes = frustumPlanes
 	}
 
-	// depth buffer so that we can draw triangles in any order and they don't overlap incorrectly
-	depthBuf := make([]float32, size*size)
-	for i := range depthBuf {
-		depthBuf[i] = 1
+	fill := r.PolygonMode != PolygonLine
+	var edges map[[2]V4]bool
+	if r.PolygonMode != PolygonFill {
+		edges = map[[2]V4]bool{}
 	}
 
-	for _, d := range data {
-		ra := d.Vertices[0]
-		rb := d.Vertices[1]
-		rc := d.Vertices[2]
-
-		interpA := d.Interps[0]
-		interpB := d.Interps[1]
-		interpC := d.Interps[2]
-
-		a := ra.MultiplyScalar(1.0 / ra[3])
-		b := rb.MultiplyScalar(1.0 / rb[3])
-		c := rc.MultiplyScalar(1.0 / rc[3])
-
-		// only show front facing triangles (CCW)
-		// https://www.opengl.org/registry/doc/glspec44.core.pdf p.426
-		area := a[0]*b[1] - b[0]*a[1] + b[0]*c[1] - c[0]*b[1] + c[0]*a[1] - a[0]*c[1]
-		if area <= 0 {
+	// opaque triangles go first, then lines, then transparent triangles are blended over them
+	prims := make([]primitive, 0, len(data))
+	var transparent []*ProcessedTriangle
+	for i := range data {
+		if m := data[i].Triangle.Material; m != nil && m.transparent() {
+			transparent = append(transparent, &data[i])
 			continue
 		}
-
-		// if all points are outside clip-space, skip this triangle
-		if clip(a) && clip(b) && clip(c) {
-			continue
+		n := len(prims)
+		prims = r.appendPrimitives(prims, &data[i], planes, nil)
+		if edges != nil && len(prims) > n {
+			r.appendEdges(&data[i], edges)
 		}
-
-		minX := min(a[0], min(b[0], c[0]))
-		maxX := max(a[0], max(b[0], c[0]))
-		minY := min(a[1], min(b[1], c[1]))
-		maxY := max(a[1], max(b[1], c[1]))
-
-		// create bounding boxes for triangles
-		// it's really slow without bounding boxes
-		minPx := int(math.Floor((float64(minX)+1.0)/2.0*float64(size) - 0.5))
-		if minPx < 0 {
-			minPx = 0
-		}
-		minPy := int(math.Floor((float64(minY)+1.0)/2.0*float64(size) - 0.5))
-		if minPy < 0 {
-			minPy = 0
-		}
-		maxPx := int(math.Ceil((float64(maxX)+1.0)/2.0*float64(size) - 0.5))
-		if maxPx >= size {
-			maxPx = size - 1
-		}
-		maxPy := int(math.Ceil((float64(maxY)+1.0)/2.0*float64(size) - 0.5))
-		if maxPy >= size {
-			maxPy = size - 1
+	}
+	if fill {
+		r.rasterize(prims)
+	}
+	if r.deferred != nil {
+		if r.SSAO {
+			if err := r.gBufferAmbientOcclusion(); err != nil {
+				return err
+			}
 		}
+		r.lightGBuffer()
+	}
 
-		// generate all pixels that fall into this box
-		for py := minPy; py < maxPy; py++ {
-			for px := minPx; px < maxPx; px++ {
-				// check which pixels have their center inside the triangle
-				wx := float32(px) + 0.5
-				wy := float32(py) + 0.5
-
-				x := wx/fsize*2.0 - 1.0
-				y := wy/fsize*2.0 - 1.0
-
-				s0 := side(a[0], a[1], b[0], b[1], x, y)
-				s1 := side(b[0], b[1], c[0], c[1], x, y)
-				s2 := side(c[0], c[1], a[0], a[1], x, y)
-
-				if s0 == s1 && s1 == s2 {
-					// calculate depth at x,y on the surface of the triangle
-					// intersection between line and plane to get depth
-
-					// calculate barycentric coordinates http://en.wikipedia.org/wiki/Barycentric_coordinate_system
-					bdenom := (b[1]-c[1])*(a[0]-c[0]) + (c[0]-b[0])*(a[1]-c[1])
-					ba := ((b[1]-c[1])*(x-c[0]) + (c[0]-b[0])*(y-c[1])) / bdenom
-					bb := ((c[1]-a[1])*(x-c[0]) + (a[0]-c[0])*(y-c[1])) / bdenom
-					bc := 1 - ba - bb
-
-					// https://www.opengl.org/registry/doc/glspec44.core.pdf p.427
-					depth := ba*a[2] + bb*b[2] + bc*c[2]
-					if depth >= -1 && depth <= depthBuf[py*size+px] {
-						depthBuf[py*size+px] = depth
-
-						ia := ba / ra[3]
-						ib := bb / rb[3]
-						ic := bc / rc[3]
-						idenom := ia + ib + ic
-
-						interp := [interpCount]float32{}
-						for i := range interp {
-							interp[i] = (interpA[i]*ia + interpB[i]*ib + interpC[i]*ic) / idenom
-						}
-
-						var c color.Color
-						if d.Texture == nil {
-							c = color.NRGBA{
-								uint8(interp[0] * 255),
-								uint8(interp[1] * 255),
-								uint8(interp[2] * 255),
-								255,
-							}
-						} else {
-							// wrap to 0-1
-							_, u := math.Modf(float64(interp[3]))
-							_, v := math.Modf(float64(interp[4]))
-							if u < 0 {
-								u = 1 + u
-							}
-							if v < 0 {
-								v = 1 + v
-							}
-							tx := int(float32(u) * float32(d.Texture.Bounds().Max.X))
-							ty := int(float32(v) * float32(d.Texture.Bounds().Max.Y))
-							c = d.Texture.At(tx, d.Texture.Bounds().Max.Y-ty)
-						}
-
-						img.Set(px, size-py, c) // origin is bottom-left
-					}
-				}
-			}
+	if !r.depthOnly {
+		mvp := r.uniforms.ModelViewProjection
+		for _, l := range scene.Lines {
+			a, b := l.Vertices[0], l.Vertices[1]
+			r.appendLine(mvp.MultiplyV4(V4{a[0], a[1], a[2], 1}), mvp.MultiplyV4(V4{b[0], b[1], b[2], 1}), l.Color)
+		}
+		for _, p := range scene.Points {
+			pos := p.Position
+			r.drawPoint(mvp.MultiplyV4(V4{pos[0], pos[1], pos[2], 1}), p.Color)
 		}
 	}
 
-	// for py := 0; py < size; py++ {
-	// 	for px := 0; px < size; px++ {
-	// 		d := (depthBuf[py*size+px] + 1.0) / 2.0
-	// 		img.Set(px, size-py, HSVToRGB(float64(d), 0.8, 1.0))
-	// 		// c := color.NRGBA{
-	// 		// 	uint8(d * 255),
-	// 		// 	uint8(d * 255),
-	// 		// 	uint8(d * 255),
-	// 		// 	255,
-	// 		// }
-	// 		// img.Set(px, py, c)
-	// 	}
-	// }
+	if len(transparent) == 0 {
+		r.drawLines()
+		return nil
+	}
 
+	// sort back to front by the average clip space w, which is the distance along the view direction
+	// the bins keep this order, and transparent triangles don't write depth so they can't hide each other
+	distance := func(d *ProcessedTriangle) float32 {
+		return d.Vertices[0][3] + d.Vertices[1][3] + d.Vertices[2][3]
+	}
+	sort.SliceStable(transparent, func(i, j int) bool {
+		return distance(transparent[i]) > distance(transparent[j])
+	})
+
+	prims = prims[:0]
+	for _, d := range transparent {
+		blend := d.Triangle.Material.blendState()
+		n := len(prims)
+		prims = r.appendPrimitives(prims, d, planes, &blend)
+		if edges != nil && len(prims) > n {
+			r.appendEdges(d, edges)
+		}
+	}
+	r.drawLines()
+	if fill {
+		r.rasterize(prims)
+	}
 	return nil
 }
+
+// appendPrimitives clips a processed triangle and appends the primitives that cover any pixels and aren't culled
+func (r *Renderer) appendPrimitives(prims []primitive, d *ProcessedTriangle, planes []V4, blend *BlendState) []primitive {
+	polygon := clipTriangle([3]clipVertex{
+		{d.Vertices[0], d.Varyings[0]},
+		{d.Vertices[1], d.Varyings[1]},
+		{d.Vertices[2], d.Varyings[2]},
+	}, planes)
+
+	for i := 1; i+1 < len(polygon); i++ {
+		p, ok := setupTriangle(polygon[0], polygon[i], polygon[i+1], d.Triangle, r.fb.width, r.fb.height, r.FrontFace)
+		if !ok || (r.CullMode == CullBack && !p.frontFacing) || (r.CullMode == CullFront && p.frontFacing) {
+			continue
+		}
+		p.blend = blend
+		p.id = d.Index
+		prims = append(prims, p)
+	}
+	return prims
+}
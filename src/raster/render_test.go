@@ -0,0 +1,902 @@
+package raster
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	. "matrix"
+)
+
+var background = color.NRGBA{127, 127, 127, 255}
+
+func triangleScene() *Scene {
+	return &Scene{
+		Triangles: []Triangle{
+			{
+				Vertices: [3]V4{{-1, -1, 0}, {1, -1, 0}, {0, 1, 0}},
+				Normals:  [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}},
+			},
+		},
+		Model: IdentityM4,
+	}
+}
+
+func TestRender(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	r := NewRenderer()
+	if err := r.Render(img, triangleScene(), NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := img.NRGBAAt(32, 24); c == background {
+		t.Errorf("triangle not drawn at center: %v", c)
+	}
+
+	for _, p := range []image.Point{{1, 1}, {62, 1}, {1, 46}, {62, 46}} {
+		if c := img.NRGBAAt(p.X, p.Y); c != background {
+			t.Errorf("unexpected color at %v: %v", p, c)
+		}
+	}
+}
+
+func TestRenderBehindCamera(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	camera := NewCamera()
+	camera.Position = V4{0, 0, -0.5}
+	r := NewRenderer()
+	if err := r.Render(img, triangleScene(), camera); err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if c := img.NRGBAAt(x, y); c != background {
+				t.Fatalf("unexpected color at (%d, %d): %v", x, y, c)
+			}
+		}
+	}
+}
+
+func TestRenderCrossingNearPlane(t *testing.T) {
+	// a floor that starts behind the camera and extends into the distance
+	scene := &Scene{
+		Triangles: []Triangle{
+			{
+				Vertices: [3]V4{{-10, -1, 10}, {10, -1, 10}, {0, -1, -10}},
+				Normals:  [3]V4{{0, 1, 0}, {0, 1, 0}, {0, 1, 0}},
+			},
+		},
+		Model: IdentityM4,
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	camera := NewCamera()
+	camera.Position = V4{0, 0, 0}
+	r := NewRenderer()
+	if err := r.Render(img, scene, camera); err != nil {
+		t.Fatal(err)
+	}
+
+	// the floor is below the horizon only
+	for x := 0; x < 32; x++ {
+		if c := img.NRGBAAt(x, 1); c != background {
+			t.Errorf("unexpected color above the horizon at (%d, 1): %v", x, c)
+		}
+	}
+	if c := img.NRGBAAt(16, 30); c == background {
+		t.Errorf("floor not drawn below the camera")
+	}
+}
+
+func TestClipTriangle(t *testing.T) {
+	v := func(x, y, z, w float32) clipVertex {
+		return clipVertex{Position: V4{x, y, z, w}, Varyings: []float32{z}}
+	}
+
+	inside := [3]clipVertex{v(0, 0, 0, 1), v(0.5, 0, 0, 1), v(0, 0.5, 0, 1)}
+	if p := clipTriangle(inside, frustumPlanes); len(p) != 3 {
+		t.Errorf("expected unclipped triangle, got %d vertices", len(p))
+	}
+
+	outside := [3]clipVertex{v(2, 0, 0, 1), v(3, 0, 0, 1), v(2, 1, 0, 1)}
+	if p := clipTriangle(outside, frustumPlanes); len(p) != 0 {
+		t.Errorf("expected rejected triangle, got %d vertices", len(p))
+	}
+
+	// one vertex behind the near plane turns the triangle into a quad
+	crossing := [3]clipVertex{v(0, 0, -3, 1), v(0.5, 0, 0, 1), v(0, 0.5, 0, 1)}
+	p := clipTriangle(crossing, []V4{nearPlane})
+	if len(p) != 4 {
+		t.Fatalf("expected quad, got %d vertices", len(p))
+	}
+	for i, cv := range p {
+		if d := nearPlane.DotProduct(cv.Position); d < -1e-6 {
+			t.Errorf("vertex %d is outside the near plane: %v", i, cv.Position)
+		}
+		if cv.Varyings[0] != cv.Position[2] {
+			t.Errorf("vertex %d has varying %f, expected %f", i, cv.Varyings[0], cv.Position[2])
+		}
+	}
+}
+
+func coverage(t *testing.T, img *image.NRGBA, scene *Scene) []bool {
+	r := NewRenderer()
+	if err := r.Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	covered := make([]bool, img.Rect.Dx()*img.Rect.Dy())
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			covered[y*img.Rect.Dx()+x] = img.NRGBAAt(x, y) != background
+		}
+	}
+	return covered
+}
+
+func TestRenderFullScreen(t *testing.T) {
+	scene := triangleScene()
+	scene.Triangles[0].Vertices = [3]V4{{-100, -100, 0}, {100, -100, 0}, {0, 100, 0}}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	for i, c := range coverage(t, img, scene) {
+		if !c {
+			t.Fatalf("pixel (%d, %d) not covered", i%33, i/33)
+		}
+	}
+}
+
+func TestRenderWatertight(t *testing.T) {
+	// a fan of triangles around an off-center point, every pixel inside must be drawn exactly once
+	center := V4{0.123, -0.071, 0}
+	ring := []V4{}
+	for i := 0; i < 11; i++ {
+		angle := float64(i) / 11 * 2 * math.Pi
+		ring = append(ring, V4{float32(math.Cos(angle))*1.7 + 0.01, float32(math.Sin(angle))*1.3 - 0.03, 0})
+	}
+
+	width, height := 61, 47
+	hits := make([]int, width*height)
+	for i := range ring {
+		scene := triangleScene()
+		scene.Triangles[0].Vertices = [3]V4{center, ring[i], ring[(i+1)%len(ring)]}
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for j, c := range coverage(t, img, scene) {
+			if c {
+				hits[j]++
+			}
+		}
+	}
+
+	total := 0
+	for _, h := range hits {
+		total += h
+	}
+	if total == 0 {
+		t.Fatal("nothing drawn")
+	}
+
+	for y := 0; y < height; y++ {
+		first, last := -1, -1
+		for x := 0; x < width; x++ {
+			switch hits[y*width+x] {
+			case 0:
+			case 1:
+				if first < 0 {
+					first = x
+				}
+				last = x
+			default:
+				t.Fatalf("pixel (%d, %d) drawn %d times", x, y, hits[y*width+x])
+			}
+		}
+		for x := first + 1; x < last; x++ {
+			if hits[y*width+x] == 0 {
+				t.Fatalf("crack at pixel (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func randomScene(n int) *Scene {
+	rng := rand.New(rand.NewSource(1))
+	scene := &Scene{Model: IdentityM4}
+	for i := 0; i < n; i++ {
+		center := V4{rng.Float32()*4 - 2, rng.Float32()*4 - 2, rng.Float32()*4 - 2}
+		t := Triangle{}
+		for j := range t.Vertices {
+			t.Vertices[j] = center.Add(V4{rng.Float32() - 0.5, rng.Float32() - 0.5, rng.Float32() - 0.5})
+			t.Normals[j] = V4{rng.Float32() - 0.5, rng.Float32() - 0.5, 1}
+		}
+		scene.Triangles = append(scene.Triangles, t)
+	}
+	return scene
+}
+
+func TestRenderTilesDeterministic(t *testing.T) {
+	scene := randomScene(2000)
+
+	serial := image.NewNRGBA(image.Rect(0, 0, 200, 150))
+	r := NewRenderer()
+	r.Workers = 1
+	r.TileSize = 1 << 16
+	if err := r.Render(serial, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tileSize := range []int{8, 17, 64} {
+		parallel := image.NewNRGBA(serial.Rect)
+		r := NewRenderer()
+		r.Workers = 8
+		r.TileSize = tileSize
+		if err := r.Render(parallel, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(serial.Pix, parallel.Pix) {
+			t.Errorf("tile size %d does not match serial output", tileSize)
+		}
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	scene := randomScene(20000)
+	img := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	r := NewRenderer()
+	for i := 0; i < b.N; i++ {
+		if err := r.Render(img, scene, NewCamera()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func testUniforms() *Uniforms {
+	camera := NewCamera()
+	view := camera.View()
+	normal, _ := view.InverseTranspose()
+	return &Uniforms{
+		Model:               IdentityM4,
+		View:                view,
+		Projection:          camera.Projection(1),
+		ModelView:           view,
+		ModelViewProjection: camera.Projection(1).Multiply(view),
+		Normal:              normal,
+	}
+}
+
+func TestProcessVerticesParallel(t *testing.T) {
+	scene := randomScene(3*vertexChunkSize + 7)
+
+	serial := NewRenderer()
+	serial.Workers = 1
+	expected := serial.ProcessVertices(scene.Triangles, testUniforms())
+
+	parallel := NewRenderer()
+	parallel.Workers = 8
+	actual := parallel.ProcessVertices(scene.Triangles, testUniforms())
+
+	if len(actual) != len(expected) {
+		t.Fatalf("got %d triangles, expected %d", len(actual), len(expected))
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(actual[i], expected[i]) {
+			t.Fatalf("triangle %d differs: %v != %v", i, actual[i], expected[i])
+		}
+	}
+}
+
+func BenchmarkProcessVertices(b *testing.B) {
+	scene := randomScene(100000)
+	u := testUniforms()
+	r := NewRenderer()
+	for i := 0; i < b.N; i++ {
+		r.ProcessVertices(scene.Triangles, u)
+	}
+}
+
+// solidShader passes the model space x coordinate through a varying and colors by it
+type solidShader struct {
+	color V4
+}
+
+func (s *solidShader) Varyings() int {
+	return 1
+}
+
+func (s *solidShader) ShadeVertex(u *Uniforms, v *Vertex, varyings []float32) V4 {
+	varyings[0] = v.Position[0]
+	return u.ModelViewProjection.MultiplyV4(V4{v.Position[0], v.Position[1], v.Position[2], 1})
+}
+
+func (s *solidShader) ShadeFragment(u *Uniforms, f *Fragment) (V4, bool) {
+	// discard the left half of the triangle
+	if f.Varyings[0] < 0 {
+		return V4{}, false
+	}
+	return s.color, true
+}
+
+func TestRenderCustomShader(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	r := NewRenderer()
+	shader := &solidShader{V4{1, 0, 0, 1}}
+	r.VertexShader = shader
+	r.FragmentShader = shader
+	if err := r.Render(img, triangleScene(), NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := img.NRGBAAt(36, 36); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("expected shader color on the right, got %v", c)
+	}
+	if c := img.NRGBAAt(28, 36); c != background {
+		t.Errorf("expected discarded fragment on the left, got %v", c)
+	}
+}
+
+type flatShader struct {
+	color V4
+}
+
+func (s *flatShader) Varyings() int {
+	return 0
+}
+
+func (s *flatShader) ShadeVertex(u *Uniforms, v *Vertex, varyings []float32) V4 {
+	return u.ModelViewProjection.MultiplyV4(V4{v.Position[0], v.Position[1], v.Position[2], 1})
+}
+
+func (s *flatShader) ShadeFragment(u *Uniforms, f *Fragment) (V4, bool) {
+	return s.color, true
+}
+
+func TestRenderMultisample(t *testing.T) {
+	if err := (&Renderer{Samples: 3}).Render(image.NewNRGBA(image.Rect(0, 0, 4, 4)), triangleScene(), NewCamera()); err == nil {
+		t.Error("expected error for unsupported sample count")
+	}
+
+	// the same fan as TestRenderWatertight, each triangle adds its sample coverage
+	center := V4{0.123, -0.071, 0}
+	ring := []V4{}
+	for i := 0; i < 7; i++ {
+		angle := float64(i) / 7 * 2 * math.Pi
+		ring = append(ring, V4{float32(math.Cos(angle)) * 1.7, float32(math.Sin(angle)) * 1.3, 0})
+	}
+
+	for _, samples := range []int{2, 4, 8} {
+		width, height := 41, 37
+		covered := make([]int, width*height)
+		for i := range ring {
+			scene := triangleScene()
+			scene.Triangles[0].Vertices = [3]V4{center, ring[i], ring[(i+1)%len(ring)]}
+
+			img := image.NewNRGBA(image.Rect(0, 0, width, height))
+			r := NewRenderer()
+			r.Samples = samples
+			shader := &flatShader{V4{1, 1, 1, 1}}
+			r.VertexShader = shader
+			r.FragmentShader = shader
+			if err := r.Render(img, scene, NewCamera()); err != nil {
+				t.Fatal(err)
+			}
+
+			// samples are averaged in linear space
+			background := float64(srgbTable[127])
+			for j := range covered {
+				c := float64(srgbTable[img.Pix[j*4]]) - background
+				covered[j] += int(math.Floor(c/(1-background)*float64(samples) + 0.5))
+			}
+		}
+
+		partial := 0
+		for j, c := range covered {
+			if c > samples {
+				t.Fatalf("%dx: pixel (%d, %d) has %d samples drawn", samples, j%width, j/width, c)
+			}
+			if c > 0 && c < samples {
+				partial++
+			}
+		}
+		if partial == 0 {
+			t.Errorf("%dx: no partially covered pixels on the edges", samples)
+		}
+		if c := covered[(height/2)*width+width/2]; c != samples {
+			t.Errorf("%dx: center pixel has %d samples drawn", samples, c)
+		}
+	}
+}
+
+func TestRenderShadow(t *testing.T) {
+	quad := func(x0, y0, x1, y1, z float32) []Triangle {
+		normals := [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+		return []Triangle{
+			{Vertices: [3]V4{{x0, y0, z}, {x1, y0, z}, {x1, y1, z}}, Normals: normals},
+			{Vertices: [3]V4{{x0, y0, z}, {x1, y1, z}, {x0, y1, z}}, Normals: normals},
+		}
+	}
+
+	// the light shines diagonally, so the occluder's shadow falls to its right on the wall
+	light := NewDirectionalLight(V4{1, 0, -1}, V3{1, 1, 1})
+	light.CastShadows = true
+	scene := &Scene{
+		Triangles: append(quad(-3, -3, 3, 3, 0), quad(-0.5, -0.5, 0.5, 0.5, 1)...),
+		Model:     IdentityM4,
+		Lights:    []Light{light},
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+
+	lit := img.NRGBAAt(18, 32)
+	shadowed := img.NRGBAAt(41, 32)
+	if lit.B == 0 {
+		t.Errorf("lit pixel = %v", lit)
+	}
+	if shadowed.B != 0 {
+		t.Errorf("shadowed pixel = %v", shadowed)
+	}
+}
+
+func TestRenderSpotShadow(t *testing.T) {
+	quad := func(x0, y0, x1, y1, z float32) []Triangle {
+		normals := [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+		return []Triangle{
+			{Vertices: [3]V4{{x0, y0, z}, {x1, y0, z}, {x1, y1, z}}, Normals: normals},
+			{Vertices: [3]V4{{x0, y0, z}, {x1, y1, z}, {x0, y1, z}}, Normals: normals},
+		}
+	}
+
+	// a spot light inside the scene's bounds, shining past the occluder onto the wall
+	light := NewSpotLight(V4{-2, 0, 4}, V4{2, 0, -4}, 0.6, 0.8, V3{1, 1, 1})
+	scene := &Scene{
+		Triangles: append(quad(-3, -3, 3, 3, 0), quad(-1, -0.5, 0, 0.5, 1)...),
+		Model:     IdentityM4,
+		Lights:    []Light{light},
+	}
+
+	unshadowed := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := NewRenderer().Render(unshadowed, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	scene.Lights[0].CastShadows = true
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the occluder's shadow falls to its right, the rest of the wall is lit as before
+	shadowed := img.NRGBAAt(36, 32)
+	if lit := unshadowed.NRGBAAt(36, 32); lit.B == 0 || shadowed.B != 0 {
+		t.Errorf("shadowed pixel = %v, without shadows %v", shadowed, lit)
+	}
+	if a, b := img.NRGBAAt(32, 50), unshadowed.NRGBAAt(32, 50); a != b || a.B == 0 {
+		t.Errorf("lit pixel = %v, without shadows %v", a, b)
+	}
+}
+
+func TestRenderShadowAcne(t *testing.T) {
+	normals := [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+	plane := []Triangle{
+		{Vertices: [3]V4{{-3, -3, 0}, {3, -3, 0}, {3, 3, 0}}, Normals: normals},
+		{Vertices: [3]V4{{-3, -3, 0}, {3, 3, 0}, {-3, 3, 0}}, Normals: normals},
+	}
+
+	// a plane lit from the front at increasingly grazing angles never shadows itself
+	var lights []Light
+	for _, direction := range []V4{{0, 0, -1}, {1, 1, -1}, {3, 1, -1}} {
+		lights = append(lights, NewDirectionalLight(direction, V3{1, 1, 1}))
+	}
+	lights = append(lights, NewSpotLight(V4{-2, 0, 2}, V4{2, 0, -2}, 0.8, 1, V3{1, 1, 1}))
+	for _, light := range lights {
+		light.ShadowMapSize = 512
+		scene := &Scene{Triangles: plane, Model: IdentityM4, Lights: []Light{light}}
+
+		expected := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+		if err := NewRenderer().Render(expected, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+		scene.Lights[0].CastShadows = true
+		img := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+		if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+
+		acne := 0
+		for i := range img.Pix {
+			if img.Pix[i] != expected.Pix[i] {
+				acne++
+			}
+		}
+		if acne > 0 {
+			t.Errorf("light %v shadows %d channels of the plane", light.Direction, acne)
+		}
+	}
+}
+
+func TestRenderTransparent(t *testing.T) {
+	layer := func(z float32, color V3, transparency float32) Triangle {
+		return Triangle{
+			Vertices: [3]V4{{-1, -1, z}, {1, -1, z}, {0, 1, z}},
+			Material: &Material{Diffuse: color, Transparency: transparency},
+		}
+	}
+
+	// submitted front to back, the transparent layers still blend from back to front over the opaque one
+	// colors are blended in linear space, 0.5 and 0.25 are encoded as 188 and 137
+	scene := &Scene{
+		Triangles: []Triangle{
+			layer(1, V3{1, 0, 0}, 0.5),
+			layer(0.5, V3{0, 1, 0}, 0.5),
+			layer(0, V3{0, 0, 1}, 0),
+		},
+		Model: IdentityM4,
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	if c, expected := img.NRGBAAt(32, 32), (color.NRGBA{188, 137, 137, 255}); c != expected {
+		t.Errorf("blended color = %v, expected %v", c, expected)
+	}
+
+	// additive blending, and transparent layers behind opaque ones are hidden
+	additive := BlendAdditive
+	scene.Triangles[0].Material.Blend = &additive
+	scene.Triangles[1].Vertices = [3]V4{{-1, -1, -1}, {1, -1, -1}, {0, 1, -1}}
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	if c, expected := img.NRGBAAt(32, 32), (color.NRGBA{188, 0, 255, 255}); c != expected {
+		t.Errorf("additive color = %v, expected %v", c, expected)
+	}
+}
+
+func TestRenderAlphaTest(t *testing.T) {
+	quad := func(z float32, material *Material) []Triangle {
+		uv := [3]V4{{0, 0}, {1, 0}, {1, 1}}
+		uv2 := [3]V4{{0, 0}, {1, 1}, {0, 1}}
+		return []Triangle{
+			{Vertices: [3]V4{{-3, -3, z}, {3, -3, z}, {3, 3, z}}, TextureCoords: uv, Material: material},
+			{Vertices: [3]V4{{-3, -3, z}, {3, 3, z}, {-3, 3, z}}, TextureCoords: uv2, Material: material},
+		}
+	}
+
+	// the left half of the cutout is see-through
+	mask := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	mask.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 0})
+	mask.SetNRGBA(1, 0, color.NRGBA{255, 0, 0, 255})
+	cutout := &Material{
+		Diffuse:     V3{1, 1, 1},
+		DiffuseMap:  NewTexture(mask),
+		AlphaCutoff: 0.5,
+		Sampler:     Sampler{Filter: FilterNearest, WrapU: WrapClampToEdge, WrapV: WrapClampToEdge},
+	}
+	wall := &Material{Diffuse: V3{0, 0, 1}}
+	scene := &Scene{
+		Triangles: append(quad(1, cutout), quad(0, wall)...),
+		Model:     IdentityM4,
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	if c := img.NRGBAAt(16, 32); c != (color.NRGBA{0, 0, 255, 255}) {
+		t.Errorf("cut out pixel = %v", c)
+	}
+	if c := img.NRGBAAt(48, 32); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("opaque pixel = %v", c)
+	}
+
+	// with alpha to coverage half the samples of a half transparent cutout are kept
+	cutout.DiffuseMap = solidTexture(color.NRGBA{255, 0, 0, 128})
+	r := NewRenderer()
+	r.Samples = 4
+	r.AlphaToCoverage = true
+	if err := r.Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	if c := img.NRGBAAt(32, 32); c != (color.NRGBA{188, 0, 188, 255}) {
+		t.Errorf("alpha to coverage pixel = %v", c)
+	}
+}
+
+func TestRenderCullMode(t *testing.T) {
+	// the back of a triangle, wound clockwise on screen with its normal pointing away from the camera
+	scene := &Scene{
+		Triangles: []Triangle{
+			{
+				Vertices: [3]V4{{-1, -1, 0}, {0, 1, 0}, {1, -1, 0}},
+				Normals:  [3]V4{{0, 0, -1}, {0, 0, -1}, {0, 0, -1}},
+				Material: &Material{Diffuse: V3{1, 1, 1}, Illum: 1},
+			},
+		},
+		Model:  IdentityM4,
+		Lights: []Light{NewDirectionalLight(V4{0, 0, -1}, V3{1, 1, 1})},
+	}
+
+	lit := color.NRGBA{255, 255, 255, 255}
+	unlit := color.NRGBA{0, 0, 0, 255}
+	cases := []struct {
+		cull      CullMode
+		frontFace Winding
+		twoSided  bool
+		expected  color.NRGBA
+	}{
+		{CullBack, CounterClockwise, false, background},
+		{CullFront, CounterClockwise, false, unlit},
+		{CullNone, CounterClockwise, false, unlit},
+		// back faces are lit from the other side
+		{CullFront, CounterClockwise, true, lit},
+		{CullNone, CounterClockwise, true, lit},
+		{CullBack, Clockwise, false, unlit},
+		{CullBack, Clockwise, true, unlit},
+		{CullFront, Clockwise, false, background},
+	}
+	for _, c := range cases {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		r := NewRenderer()
+		r.CullMode = c.cull
+		r.FrontFace = c.frontFace
+		r.TwoSided = c.twoSided
+		if err := r.Render(img, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+		if actual := img.NRGBAAt(32, 32); actual != c.expected {
+			t.Errorf("cull mode %d with front face %d and two-sided %v = %v, expected %v", c.cull, c.frontFace, c.twoSided, actual, c.expected)
+		}
+	}
+}
+
+func TestRenderPoints(t *testing.T) {
+	scene := triangleScene()
+	scene.Triangles[0].Material = &Material{Diffuse: V3{0, 0, 1}}
+	scene.Points = []Point{
+		// in front of the triangle
+		{Position: V4{0, 0, 1}, Color: V4{1, 0, 0, 1}},
+		// hidden behind it
+		{Position: V4{0, -0.5, -1}, Color: V4{0, 1, 0, 1}},
+	}
+
+	cases := []struct {
+		shape    PointShape
+		expected int
+	}{
+		{PointSquare, 25},
+		// the point is on a pixel corner, 16 pixel centers are within 2.5 pixels of it
+		{PointRound, 16},
+	}
+	for _, c := range cases {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		r := NewRenderer()
+		r.PointSize = 5
+		r.PointShape = c.shape
+		if err := r.Render(img, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+
+		red, green := 0, 0
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i] == 255 {
+				red++
+			}
+			if img.Pix[i+1] == 255 {
+				green++
+			}
+		}
+		if red != c.expected || green != 0 {
+			t.Errorf("shape %d drew %d red and %d green pixels, expected %d red", c.shape, red, green, c.expected)
+		}
+	}
+}
+
+func TestRenderDebugViews(t *testing.T) {
+	normals := [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+	// a small triangle in front of the center of a large one, submitted back to front
+	scene := &Scene{
+		Triangles: []Triangle{
+			{Vertices: [3]V4{{-3, -3, -1}, {3, -3, -1}, {0, 3, -1}}, Normals: normals},
+			{Vertices: [3]V4{{-0.5, -0.5, 0}, {0.5, -0.5, 0}, {0, 0.5, 0}}, Normals: normals},
+		},
+		Model: IdentityM4,
+	}
+
+	render := func(view DebugView) (front, back, empty color.NRGBA) {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		r := NewRenderer()
+		r.DebugView = view
+		if err := r.Render(img, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+		return img.NRGBAAt(32, 32), img.NRGBAAt(32, 50), img.NRGBAAt(0, 0)
+	}
+
+	black := color.NRGBA{0, 0, 0, 255}
+	if front, back, empty := render(DebugDepth); front != (color.NRGBA{255, 255, 255, 255}) || back != black || empty != black {
+		t.Errorf("depth = %v, %v, %v", front, back, empty)
+	}
+	if front, _, _ := render(DebugNormals); front != (color.NRGBA{128, 128, 255, 255}) {
+		t.Errorf("normal = %v", front)
+	}
+	if front, back, empty := render(DebugOverdraw); front == back || back != (color.NRGBA{0, 0, 255, 255}) || empty != black {
+		t.Errorf("overdraw = %v, %v, %v", front, back, empty)
+	}
+	if front, back, _ := render(DebugTriangles); front == back || front == black || back == black {
+		t.Errorf("triangle ids = %v, %v", front, back)
+	}
+	if front, back, _ := render(DebugObjects); front != back || front == black {
+		t.Errorf("object ids = %v, %v", front, back)
+	}
+}
+
+func TestRenderDeferred(t *testing.T) {
+	normals := [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+	opaque := &Material{Diffuse: V3{1, 0.5, 0}, Specular: V3{1, 1, 1}, Shininess: 20, Illum: 2}
+	glass := &Material{Diffuse: V3{0, 0, 1}, Transparency: 0.5, Illum: 1}
+	scene := &Scene{
+		Triangles: []Triangle{
+			{Vertices: [3]V4{{-3, -3, -1}, {3, -3, -1}, {0, 3, -1}}, Normals: normals, Material: opaque},
+			{Vertices: [3]V4{{-1, -1, 0}, {1, -1, 0}, {0, 1, 0}}, Normals: normals, Material: glass},
+			{Vertices: [3]V4{{-0.5, -2, -0.5}, {0.5, -2, -0.5}, {0, -1, -0.5}}, Normals: normals},
+		},
+		Model:   IdentityM4,
+		Lights:  []Light{NewPointLight(V4{0, 0, 2}, V3{1, 1, 1})},
+		Ambient: V3{0.1, 0.1, 0.1},
+	}
+
+	r := NewRenderer()
+	gbuffer := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := r.ExportGBuffer(gbuffer, GBufferNormal); err == nil {
+		t.Error("expected error exporting the G-buffer of a forward frame")
+	}
+
+	// deferred shading gives the same image as forward shading
+	for _, samples := range []int{1, 4} {
+		forward := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		deferred := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		r.Samples = samples
+		r.Deferred = false
+		if err := r.Render(forward, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+		r.Deferred = true
+		if err := r.Render(deferred, scene, NewCamera()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(forward.Pix, deferred.Pix) {
+			t.Errorf("%dx: deferred image differs from forward", samples)
+		}
+	}
+
+	if err := r.ExportGBuffer(gbuffer, GBufferNormal); err != nil {
+		t.Fatal(err)
+	}
+	if c := gbuffer.NRGBAAt(32, 32); c != (color.NRGBA{128, 128, 255, 255}) {
+		t.Errorf("normal = %v", c)
+	}
+	if c := gbuffer.NRGBAAt(0, 0); c != (color.NRGBA{}) {
+		t.Errorf("empty pixel = %v", c)
+	}
+	if err := r.ExportGBuffer(gbuffer, GBufferMaterial); err != nil {
+		t.Fatal(err)
+	}
+	if gbuffer.NRGBAAt(32, 32) == gbuffer.NRGBAAt(32, 45) {
+		t.Error("expected different colors for different materials")
+	}
+
+	r.FragmentShader = &flatShader{V4{1, 1, 1, 1}}
+	if err := r.Render(gbuffer, scene, NewCamera()); err == nil {
+		t.Error("expected error for a shader without deferred support")
+	}
+}
+
+func TestRenderSSAO(t *testing.T) {
+	quad := func(a, b, c, d, normal V4) []Triangle {
+		n := [3]V4{normal, normal, normal}
+		return []Triangle{
+			{Vertices: [3]V4{a, b, c}, Normals: n},
+			{Vertices: [3]V4{a, c, d}, Normals: n},
+		}
+	}
+	// a floor meeting a wall, seen from above the floor
+	scene := &Scene{
+		Triangles: append(
+			quad(V4{-4, -1, 2}, V4{4, -1, 2}, V4{4, -1, -2}, V4{-4, -1, -2}, V4{0, 1, 0}),
+			quad(V4{-4, -1, -2}, V4{4, -1, -2}, V4{4, 3, -2}, V4{-4, 3, -2}, V4{0, 0, 1})...),
+		Model: IdentityM4,
+	}
+	camera := NewCamera()
+	camera.Position = V4{0, 1, 3}
+
+	r := NewRenderer()
+	r.DebugView = DebugAmbientOcclusion
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := r.Render(img, scene, camera); err != nil {
+		t.Fatal(err)
+	}
+	// flat surfaces aren't occluded, the crease is
+	for _, y := range []int{30, 60} {
+		if c := img.NRGBAAt(32, y); c != (color.NRGBA{255, 255, 255, 255}) {
+			t.Errorf("flat surface at row %d = %v", y, c)
+		}
+	}
+	crease := 255
+	for y := 40; y < 60; y++ {
+		if c := int(img.NRGBAAt(32, y).R); c < crease {
+			crease = c
+		}
+	}
+	if crease > 215 {
+		t.Errorf("crease ambient light = %d", crease)
+	}
+
+	// only the ambient light is darkened
+	for i := range scene.Triangles {
+		scene.Triangles[i].Material = &Material{Ambient: V3{1, 1, 1}, Illum: 1}
+	}
+	scene.Ambient = V3{0.5, 0.5, 0.5}
+	r.DebugView = DebugNone
+	lit := image.NewNRGBA(img.Bounds())
+	if err := r.Render(lit, scene, camera); err != nil {
+		t.Fatal(err)
+	}
+	r.SSAO = true
+	if err := r.Render(img, scene, camera); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := img.NRGBAAt(32, 60), lit.NRGBAAt(32, 60); a != b {
+		t.Errorf("flat surface = %v, expected %v", a, b)
+	}
+	if a, b := img.NRGBAAt(32, 49), lit.NRGBAAt(32, 49); a.R >= b.R {
+		t.Errorf("crease = %v, expected darker than %v", a, b)
+	}
+
+	// deferred shading takes the depth from the G-buffer instead of a prepass
+	for _, samples := range []int{1, 4} {
+		deferred := NewRenderer()
+		deferred.Deferred = true
+		deferred.SSAO = true
+		deferred.Samples = samples
+		out := image.NewNRGBA(img.Bounds())
+		if err := deferred.Render(out, scene, camera); err != nil {
+			t.Fatal(err)
+		}
+		if deferred.depthRenderer != nil {
+			t.Errorf("deferred SSAO with %d samples ran a depth prepass", samples)
+		}
+		for _, y := range []int{49, 60} {
+			if a, b := out.NRGBAAt(32, y), img.NRGBAAt(32, y); abs(float32(a.R)-float32(b.R)) > 2 {
+				t.Errorf("deferred with %d samples at row %d = %v, forward = %v", samples, y, a, b)
+			}
+		}
+	}
+}
+
+func TestRenderSSAOThinImage(t *testing.T) {
+	// a plane filling the image, so that samples reach its last row and column
+	normals := [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+	scene := &Scene{
+		Triangles: []Triangle{
+			{Vertices: [3]V4{{-20, -20, 0}, {20, -20, 0}, {20, 20, 0}}, Normals: normals},
+			{Vertices: [3]V4{{-20, -20, 0}, {20, 20, 0}, {-20, 20, 0}}, Normals: normals},
+		},
+		Model: IdentityM4,
+	}
+	for _, size := range []image.Rectangle{image.Rect(0, 0, 1, 40), image.Rect(0, 0, 40, 1)} {
+		for _, deferred := range []bool{false, true} {
+			r := NewRenderer()
+			r.SSAO = true
+			r.Deferred = deferred
+			img := image.NewNRGBA(size)
+			if err := r.Render(img, scene, NewCamera()); err != nil {
+				t.Errorf("%v deferred %v: %v", size.Size(), deferred, err)
+			}
+		}
+	}
+}
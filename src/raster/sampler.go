@@ -0,0 +1,158 @@
+package raster
+
+import (
+	"image"
+	"math"
+
+	. "matrix"
+)
+
+type Filter int
+
+const (
+	FilterNearest Filter = iota
+	FilterBilinear
+)
+
+// MipFilter selects how mipmap levels are chosen
+type MipFilter int
+
+const (
+	// only sample the full resolution image
+	MipNone MipFilter = iota
+	// sample the closest level
+	MipNearest
+	// blend the two closest levels, which is trilinear filtering when used with FilterBilinear
+	MipLinear
+)
+
+type Wrap int
+
+const (
+	WrapRepeat Wrap = iota
+	WrapClampToEdge
+	WrapMirroredRepeat
+)
+
+// Sampler looks up texture colors from texture coordinates
+// texture coordinates have their origin at the bottom-left of the image, like in OBJ files
+type Sampler struct {
+	Filter    Filter
+	MipFilter MipFilter
+	WrapU     Wrap
+	WrapV     Wrap
+	// added to the level of detail, positive values select blurrier levels
+	LODBias float32
+}
+
+var DefaultSampler = Sampler{
+	Filter:    FilterBilinear,
+	MipFilter: MipLinear,
+	WrapU:     WrapRepeat,
+	WrapV:     WrapRepeat,
+}
+
+func wrap(i, n int, mode Wrap) int {
+	switch mode {
+	case WrapClampToEdge:
+		if i < 0 {
+			return 0
+		}
+		if i >= n {
+			return n - 1
+		}
+		return i
+	case WrapMirroredRepeat:
+		period := 2 * n
+		i = ((i % period) + period) % period
+		if i >= n {
+			i = period - 1 - i
+		}
+		return i
+	default:
+		return ((i % n) + n) % n
+	}
+}
+
+// texel reads the texel at x, y where y counts rows from the bottom of the image
+// sRGB colors are converted to linear, alpha is always linear
+func texel(texture *image.NRGBA, x, y int, srgb bool) V4 {
+	offset := (texture.Rect.Dy()-1-y)*texture.Stride + x*4
+	p := texture.Pix[offset : offset+4 : offset+4]
+	if srgb {
+		return V4{srgbTable[p[0]], srgbTable[p[1]], srgbTable[p[2]], float32(p[3]) / 255}
+	}
+	return V4{float32(p[0]) / 255, float32(p[1]) / 255, float32(p[2]) / 255, float32(p[3]) / 255}
+}
+
+// Sample returns the RGBA color of the full resolution texture at u, v with components in the range 0-1
+func (s Sampler) Sample(t *Texture, u, v float32) V4 {
+	return s.sampleLevel(t.Levels[0], t.SRGB, u, v)
+}
+
+// SampleGrad returns the RGBA color of the texture at u, v using the screen-space derivatives
+// of the texture coordinates to pick the mipmap levels
+func (s Sampler) SampleGrad(t *Texture, u, v, dudx, dvdx, dudy, dvdy float32) V4 {
+	if s.MipFilter == MipNone || len(t.Levels) == 1 {
+		return s.sampleLevel(t.Levels[0], t.SRGB, u, v)
+	}
+
+	// the level of detail is the log of how many texels one pixel step covers
+	// https://www.opengl.org/registry/doc/glspec44.core.pdf p.247
+	width := float32(t.Levels[0].Rect.Dx())
+	height := float32(t.Levels[0].Rect.Dy())
+	lengthX := V2{dudx * width, dvdx * height}.Length()
+	lengthY := V2{dudy * width, dvdy * height}.Length()
+	lod := float32(math.Log2(float64(max(lengthX, lengthY)))) + s.LODBias
+
+	last := float32(len(t.Levels) - 1)
+	if !(lod > 0) {
+		// also catches NaN from zero derivatives
+		lod = 0
+	}
+	if lod > last {
+		lod = last
+	}
+
+	if s.MipFilter == MipNearest {
+		return s.sampleLevel(t.Levels[int(lod+0.5)], t.SRGB, u, v)
+	}
+
+	level := int(lod)
+	if float32(level) == last {
+		return s.sampleLevel(t.Levels[level], t.SRGB, u, v)
+	}
+	fine := s.sampleLevel(t.Levels[level], t.SRGB, u, v)
+	coarse := s.sampleLevel(t.Levels[level+1], t.SRGB, u, v)
+	return fine.Lerp(coarse, lod-float32(level))
+}
+
+func (s Sampler) sampleLevel(texture *image.NRGBA, srgb bool, u, v float32) V4 {
+	width := texture.Rect.Dx()
+	height := texture.Rect.Dy()
+	x := u * float32(width)
+	y := v * float32(height)
+
+	if s.Filter == FilterNearest {
+		tx := wrap(int(math.Floor(float64(x))), width, s.WrapU)
+		ty := wrap(int(math.Floor(float64(y))), height, s.WrapV)
+		return texel(texture, tx, ty, srgb)
+	}
+
+	// blend the four texels whose centers surround the sample point
+	x -= 0.5
+	y -= 0.5
+	fx := float32(math.Floor(float64(x)))
+	fy := float32(math.Floor(float64(y)))
+	tx := x - fx
+	ty := y - fy
+
+	x0 := wrap(int(fx), width, s.WrapU)
+	x1 := wrap(int(fx)+1, width, s.WrapU)
+	y0 := wrap(int(fy), height, s.WrapV)
+	y1 := wrap(int(fy)+1, height, s.WrapV)
+
+	top := texel(texture, x0, y0, srgb).Lerp(texel(texture, x1, y0, srgb), tx)
+	bottom := texel(texture, x0, y1, srgb).Lerp(texel(texture, x1, y1, srgb), tx)
+	return top.Lerp(bottom, ty)
+}
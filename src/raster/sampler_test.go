@@ -0,0 +1,185 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	. "matrix"
+)
+
+func checkerTexture() *image.NRGBA {
+	// top row black, white; bottom row red, green
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 255})
+	img.SetNRGBA(0, 1, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(1, 1, color.NRGBA{0, 255, 0, 255})
+	return img
+}
+
+func closeV4(a, b V4) bool {
+	return a.Subtract(b).Length() < 1e-5
+}
+
+func TestWrap(t *testing.T) {
+	cases := []struct {
+		i, n     int
+		mode     Wrap
+		expected int
+	}{
+		{-1, 4, WrapRepeat, 3},
+		{4, 4, WrapRepeat, 0},
+		{9, 4, WrapRepeat, 1},
+		{-1, 4, WrapClampToEdge, 0},
+		{7, 4, WrapClampToEdge, 3},
+		{-1, 4, WrapMirroredRepeat, 0},
+		{4, 4, WrapMirroredRepeat, 3},
+		{5, 4, WrapMirroredRepeat, 2},
+		{8, 4, WrapMirroredRepeat, 0},
+	}
+	for _, c := range cases {
+		if actual := wrap(c.i, c.n, c.mode); actual != c.expected {
+			t.Errorf("wrap(%d, %d, %d) = %d, expected %d", c.i, c.n, c.mode, actual, c.expected)
+		}
+	}
+}
+
+func TestSampleNearest(t *testing.T) {
+	texture := checkerTexture()
+	s := Sampler{Filter: FilterNearest}
+
+	cases := []struct {
+		u, v     float32
+		expected V4
+	}{
+		// v = 0 is the bottom of the image
+		{0.25, 0.25, V4{1, 0, 0, 1}},
+		{0.75, 0.25, V4{0, 1, 0, 1}},
+		{0.25, 0.75, V4{0, 0, 0, 1}},
+		{0.75, 0.75, V4{1, 1, 1, 1}},
+		// edges of the texture stay in bounds
+		{0, 0, V4{1, 0, 0, 1}},
+		{0.999, 0.999, V4{1, 1, 1, 1}},
+		{1.25, -0.75, V4{1, 0, 0, 1}},
+	}
+	for _, c := range cases {
+		if actual := s.Sample(NewTexture(texture), c.u, c.v); !closeV4(actual, c.expected) {
+			t.Errorf("Sample(%f, %f) = %v, expected %v", c.u, c.v, actual, c.expected)
+		}
+	}
+}
+
+func TestSampleBilinear(t *testing.T) {
+	texture := checkerTexture()
+
+	s := Sampler{Filter: FilterBilinear, WrapU: WrapClampToEdge, WrapV: WrapClampToEdge}
+	if actual := s.Sample(NewTexture(texture), 0.5, 0.5); !closeV4(actual, V4{0.5, 0.5, 0.25, 1}) {
+		t.Errorf("center sample = %v", actual)
+	}
+	if actual := s.Sample(NewTexture(texture), 0.25, 0.75); !closeV4(actual, V4{0, 0, 0, 1}) {
+		t.Errorf("texel center sample = %v", actual)
+	}
+	if actual := s.Sample(NewTexture(texture), 0, 0.25); !closeV4(actual, V4{1, 0, 0, 1}) {
+		t.Errorf("clamped edge sample = %v", actual)
+	}
+
+	// with repeat the left edge blends with the right column
+	s = Sampler{Filter: FilterBilinear, WrapU: WrapRepeat, WrapV: WrapClampToEdge}
+	if actual := s.Sample(NewTexture(texture), 0, 0.25); !closeV4(actual, V4{0.5, 0.5, 0, 1}) {
+		t.Errorf("repeated edge sample = %v", actual)
+	}
+}
+
+func TestNewTexture(t *testing.T) {
+	texture := NewTexture(image.NewNRGBA(image.Rect(0, 0, 8, 2)))
+	sizes := []image.Point{{8, 2}, {4, 1}, {2, 1}, {1, 1}}
+	if len(texture.Levels) != len(sizes) {
+		t.Fatalf("got %d levels, expected %d", len(texture.Levels), len(sizes))
+	}
+	for i, size := range sizes {
+		if actual := texture.Levels[i].Rect.Size(); actual != size {
+			t.Errorf("level %d is %v, expected %v", i, actual, size)
+		}
+	}
+
+	// the last level is the average of the whole image
+	texture = NewTexture(checkerTexture())
+	if actual := texel(texture.Levels[1], 0, 0, false); !closeV4(actual, V4{128.0 / 255, 128.0 / 255, 64.0 / 255, 1}) {
+		t.Errorf("1x1 level = %v", actual)
+	}
+}
+
+func TestSampleGrad(t *testing.T) {
+	texture := NewTexture(checkerTexture())
+	average := texel(texture.Levels[1], 0, 0, false)
+	s := Sampler{Filter: FilterNearest, MipFilter: MipNearest}
+
+	// one texel per pixel uses the full resolution
+	if actual := s.SampleGrad(texture, 0.25, 0.25, 0.5, 0, 0, 0.5); !closeV4(actual, V4{1, 0, 0, 1}) {
+		t.Errorf("magnified sample = %v", actual)
+	}
+	// two texels per pixel uses the next level
+	if actual := s.SampleGrad(texture, 0.25, 0.25, 1, 0, 0, 1); !closeV4(actual, average) {
+		t.Errorf("minified sample = %v", actual)
+	}
+	// zero derivatives don't break the level selection
+	if actual := s.SampleGrad(texture, 0.25, 0.25, 0, 0, 0, 0); !closeV4(actual, V4{1, 0, 0, 1}) {
+		t.Errorf("constant sample = %v", actual)
+	}
+
+	s.LODBias = 1
+	if actual := s.SampleGrad(texture, 0.25, 0.25, 0.5, 0, 0, 0.5); !closeV4(actual, average) {
+		t.Errorf("biased sample = %v", actual)
+	}
+
+	// halfway between the levels blends them
+	s = Sampler{Filter: FilterNearest, MipFilter: MipLinear}
+	d := float32(math.Sqrt2) / 2
+	expected := V4{1, 0, 0, 1}.Lerp(average, 0.5)
+	if actual := s.SampleGrad(texture, 0.25, 0.25, d, 0, 0, d); !closeV4(actual, expected) {
+		t.Errorf("trilinear sample = %v, expected %v", actual, expected)
+	}
+}
+
+func TestSRGB(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		if c := int(linearToSRGB(srgbTable[i])*255 + 0.5); c != i {
+			t.Errorf("%d encoded back as %d", i, c)
+		}
+	}
+	if c := srgbToLinear(0.5); abs(c-0.214) > 0.001 {
+		t.Errorf("srgbToLinear(0.5) = %v", c)
+	}
+
+	// mipmaps of sRGB textures average in linear space
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 255})
+	if c := NewSRGBTexture(img).Levels[1].NRGBAAt(0, 0); c != (color.NRGBA{188, 188, 188, 255}) {
+		t.Errorf("sRGB mipmap = %v", c)
+	}
+	if c := NewTexture(img).Levels[1].NRGBAAt(0, 0); c != (color.NRGBA{128, 128, 128, 255}) {
+		t.Errorf("linear mipmap = %v", c)
+	}
+}
+
+func TestTonemap(t *testing.T) {
+	cases := []struct {
+		tonemap Tonemap
+		in, out float32
+	}{
+		{TonemapNone, 0.5, 0.5},
+		{TonemapNone, 4, 1},
+		{TonemapReinhard, 1, 0.5},
+		{TonemapReinhard, 3, 0.75},
+		{TonemapACES, 0, 0},
+		{TonemapACES, 100, 1},
+	}
+	for _, c := range cases {
+		if out := c.tonemap.apply(V4{c.in, c.in, c.in, 0.5}); abs(out[0]-c.out) > 0.01 || out[3] != 0.5 {
+			t.Errorf("tonemap %d of %v = %v, expected %v", c.tonemap, c.in, out, c.out)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package raster
+
+import (
+	"image"
+	"math"
+	"obj"
+
+	. "matrix"
+)
+
+// Material describes how a surface reflects light, following the MTL illumination model
+type Material struct {
+	Ambient  V3
+	Diffuse  V3
+	Specular V3
+	Emissive V3
+	// specular exponent
+	Shininess float32
+	// MTL illumination model, 0 is unlit, 1 is ambient and diffuse, 2 and up add specular highlights
+	Illum int
+	// 0 is opaque and 1 is invisible, the alpha of fragments is multiplied by 1 - Transparency
+	Transparency float32
+	// how transparent fragments are combined with the framebuffer, nil uses BlendOver for
+	// transparent materials and BlendOpaque otherwise
+	Blend *BlendState
+	// fragments with a lower alpha are discarded and the rest are opaque, 0 disables the alpha test
+	AlphaCutoff float32
+
+	// multiplied with the diffuse, specular and emissive colors
+	DiffuseMap  *Texture
+	SpecularMap *Texture
+	EmissiveMap *Texture
+	// multiplied with the shininess and alpha, only the red channel is used
+	ShininessMap *Texture
+	AlphaMap     *Texture
+	// tangent-space normals
+	NormalMap *Texture
+	// heights that perturb the normal, used when there is no normal map
+	BumpMap *Texture
+	// how strongly a height difference of one texel tilts the normal, 0 is the same as 1
+	BumpScale float32
+	Sampler   Sampler
+}
+
+const defaultAlphaCutoff = 0.5
+
+// DefaultMaterial is used for objects that don't reference a material
+var DefaultMaterial = Material{
+	Diffuse:   V3{0.4, 0.4, 1},
+	Illum:     1,
+	BumpScale: 1,
+	Sampler:   DefaultSampler,
+}
+
+// textureKey identifies a converted texture, the same image can be used both as color and as data
+type textureKey struct {
+	image image.Image
+	srgb  bool
+}
+
+func newMaterial(m obj.Material, textures map[textureKey]*Texture) *Material {
+	if m.Name == "" {
+		material := DefaultMaterial
+		return &material
+	}
+
+	// d is the opacity and Tr is its inverse, a missing d is parsed as 0
+	transparency := m.Tr
+	if m.D > 0 {
+		transparency = 1 - m.D
+	}
+
+	// alpha masks are cut out rather than blended, unless the whole material is see-through,
+	// diffuse textures are only masks when their alpha is all or nothing, otherwise they're blended
+	var alphaCutoff float32
+	diffuseMap := textures[textureKey{m.MapKd, true}]
+	if transparency == 0 && (m.MapD != nil || (diffuseMap != nil && diffuseMap.translucent && !diffuseMap.partial)) {
+		alphaCutoff = defaultAlphaCutoff
+	}
+
+	// map_bump and bump are both height maps, files commonly use either
+	bumpMap := m.MapBump
+	if bumpMap == nil {
+		bumpMap = m.Bump
+	}
+
+	return &Material{
+		Ambient:      V3{m.Ka[0], m.Ka[1], m.Ka[2]},
+		Diffuse:      V3{m.Kd[0], m.Kd[1], m.Kd[2]},
+		Specular:     V3{m.Ks[0], m.Ks[1], m.Ks[2]},
+		Emissive:     V3{m.Ke[0], m.Ke[1], m.Ke[2]},
+		Shininess:    m.Ns,
+		Illum:        m.Illum,
+		Transparency: transparency,
+		AlphaCutoff:  alphaCutoff,
+		DiffuseMap:   diffuseMap,
+		SpecularMap:  textures[textureKey{m.MapKs, true}],
+		EmissiveMap:  textures[textureKey{m.MapKe, true}],
+		ShininessMap: textures[textureKey{m.MapNs, false}],
+		AlphaMap:     textures[textureKey{m.MapD, false}],
+		NormalMap:    textures[textureKey{m.Norm, false}],
+		BumpMap:      textures[textureKey{bumpMap, false}],
+		BumpScale:    1,
+		Sampler:      DefaultSampler,
+	}
+}
+
+// transparent reports whether triangles with the material need to be blended
+func (m *Material) transparent() bool {
+	if m.Blend != nil {
+		return *m.Blend != BlendOpaque
+	}
+	if m.AlphaCutoff > 0 {
+		return m.Transparency > 0
+	}
+	return m.Transparency > 0 || m.AlphaMap != nil || (m.DiffuseMap != nil && m.DiffuseMap.translucent)
+}
+
+func (m *Material) blendState() BlendState {
+	if m.Blend != nil {
+		return *m.Blend
+	}
+	return BlendOver
+}
+
+type Triangle struct {
+	Vertices      [3]V4
+	TextureCoords [3]V4
+	Normals       [3]V4
+	// tangents point along increasing u, w is the handedness of the bitangent (1 or -1)
+	Tangents [3]V4
+	Material *Material
+	// index of the object the triangle was loaded from
+	Object int
+}
+
+// Line is a segment drawn with a solid color
+type Line struct {
+	Vertices [2]V4
+	Color    V4
+}
+
+// Point is drawn as a sprite with a solid linear color
+type Point struct {
+	Position V4
+	Color    V4
+}
+
+type Scene struct {
+	Triangles []Triangle
+	Lines     []Line
+	Points    []Point
+	Model     M4
+	Lights    []Light
+	// light that reaches every surface, scaled by the ambient color of the material
+	Ambient V3
+	Fog     Fog
+}
+
+// LoadScene reads an OBJ file (and any materials it references) into a scene
+func LoadScene(objPath string) (*Scene, error) {
+	objects, err := obj.Load(objPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewScene(objects), nil
+}
+
+// NewScene triangulates the faces of the loaded objects and converts their textures
+func NewScene(objects []obj.Object) *Scene {
+	scene := &Scene{
+		Model:   IdentityM4,
+		Ambient: V3{1, 1, 1},
+	}
+	scene.Lights = []Light{NewDirectionalLight(V4{-1, -1, -1}, V3{1, 1, 1})}
+
+	// color maps are stored in sRGB, the other maps hold linear data
+	convertedTextures := map[textureKey]*Texture{}
+	for _, obj := range objects {
+		m := obj.Material
+		keys := []textureKey{
+			{m.MapKd, true}, {m.MapKs, true}, {m.MapKe, true},
+			{m.MapNs, false}, {m.MapD, false}, {m.MapBump, false}, {m.Bump, false},
+			{m.Norm, false},
+		}
+		for _, key := range keys {
+			if _, ok := convertedTextures[key]; ok || key.image == nil {
+				continue
+			}
+			convertedTextures[key] = newTexture(convertImage(key.image), key.srgb)
+		}
+	}
+
+	for o, obj := range objects {
+		material := newMaterial(obj.Material, convertedTextures)
+		first := len(scene.Triangles)
+
+		for _, f := range obj.Faces {
+			normals := f.Normals[:]
+			if len(normals) == 0 {
+				// if normals are missing, fill them in
+				// degenerate faces keep a zero normal rather than NaNs
+				normal := f.Vertices[1].Subtract(f.Vertices[0]).CrossProduct(f.Vertices[2].Subtract(f.Vertices[0]))
+				if l := normal.Length(); l > 0 {
+					normal = normal.DivideScalar(l)
+				}
+				for range f.Vertices {
+					normals = append(normals, normal)
+				}
+			}
+
+			textureCoords := f.TextureCoords[:]
+			for len(textureCoords) < len(f.Vertices) {
+				textureCoords = append(textureCoords, V4{})
+			}
+
+			// generate indices to generate triangles from polygons
+			// https://www.siggraph.org/education/materials/HyperGraph/scanline/outprims/polygon1.htm
+			for i := 0; i < len(f.Vertices)-2; i++ {
+				triangle := Triangle{
+					Vertices:      [3]V4{f.Vertices[0], f.Vertices[i+1], f.Vertices[i+2]},
+					TextureCoords: [3]V4{textureCoords[0], textureCoords[i+1], textureCoords[i+2]},
+					Normals:       [3]V4{normals[0], normals[i+1], normals[i+2]},
+					Material:      material,
+					Object:        o,
+				}
+				scene.Triangles = append(scene.Triangles, triangle)
+			}
+		}
+
+		generateTangents(scene.Triangles[first:])
+
+		color := V4{material.Diffuse[0], material.Diffuse[1], material.Diffuse[2], 1 - material.Transparency}
+		for _, l := range obj.Lines {
+			for i := 0; i+1 < len(l.Vertices); i++ {
+				scene.Lines = append(scene.Lines, Line{
+					Vertices: [2]V4{l.Vertices[i], l.Vertices[i+1]},
+					Color:    color,
+				})
+			}
+		}
+
+		for _, p := range obj.Points {
+			// vertex colors are authored in sRGB like textures
+			c := V4{srgbToLinear(p.Color[0]), srgbToLinear(p.Color[1]), srgbToLinear(p.Color[2]), p.Color[3]}
+			if c[3] == 0 {
+				// no vertex color
+				c = color
+			}
+			scene.Points = append(scene.Points, Point{Position: p.Vertex, Color: c})
+		}
+	}
+
+	return scene
+}
+
+// bounds returns a sphere in world space around all triangles of the scene
+func (s *Scene) bounds() (V3, float32) {
+	lo := V3{float32(math.Inf(1)), float32(math.Inf(1)), float32(math.Inf(1))}
+	hi := lo.Negate()
+	for _, t := range s.Triangles {
+		for _, v := range t.Vertices {
+			p := s.Model.MultiplyV4(V4{v[0], v[1], v[2], 1})
+			lo = lo.Minimum(V3{p[0], p[1], p[2]})
+			hi = hi.Maximum(V3{p[0], p[1], p[2]})
+		}
+	}
+	if len(s.Triangles) == 0 {
+		return V3{}, 1
+	}
+
+	center := lo.Add(hi).MultiplyScalar(0.5)
+	radius := hi.Distance(lo) / 2
+	if radius == 0 {
+		radius = 1
+	}
+	return center, radius
+}
+
+func convertImage(src image.Image) *image.NRGBA {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for x := 0; x < bounds.Dx(); x++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			oldColor := src.At(bounds.Min.X+x, bounds.Min.Y+y)
+			newColor := dst.ColorModel().Convert(oldColor)
+			dst.Set(x, y, newColor)
+		}
+	}
+	return dst
+}
+
+// generateTangents fills in the tangents of the triangles from their positions and texture coordinates,
+// vertices with the same position, normal and texture coordinate share the average of their triangles' tangents
+// http://www.terathon.com/code/tangent.html
+func generateTangents(triangles []Triangle) {
+	type vertex struct {
+		position, textureCoord, normal V4
+	}
+	type basis struct {
+		tangent, bitangent V3
+	}
+	sums := map[vertex]basis{}
+
+	for _, t := range triangles {
+		p0, p1, p2 := t.Vertices[0], t.Vertices[1], t.Vertices[2]
+		uv0, uv1, uv2 := t.TextureCoords[0], t.TextureCoords[1], t.TextureCoords[2]
+		e1 := V3{p1[0] - p0[0], p1[1] - p0[1], p1[2] - p0[2]}
+		e2 := V3{p2[0] - p0[0], p2[1] - p0[1], p2[2] - p0[2]}
+		du1, dv1 := uv1[0]-uv0[0], uv1[1]-uv0[1]
+		du2, dv2 := uv2[0]-uv0[0], uv2[1]-uv0[1]
+
+		det := du1*dv2 - du2*dv1
+		if det == 0 {
+			// the texture coordinates don't span an area
+			continue
+		}
+		tangent := e1.MultiplyScalar(dv2).Subtract(e2.MultiplyScalar(dv1)).DivideScalar(det)
+		bitangent := e2.MultiplyScalar(du1).Subtract(e1.MultiplyScalar(du2)).DivideScalar(det)
+
+		for i := range t.Vertices {
+			v := vertex{t.Vertices[i], t.TextureCoords[i], t.Normals[i]}
+			sum := sums[v]
+			sums[v] = basis{sum.tangent.Add(tangent), sum.bitangent.Add(bitangent)}
+		}
+	}
+
+	for j := range triangles {
+		t := &triangles[j]
+		for i := range t.Vertices {
+			sum, ok := sums[vertex{t.Vertices[i], t.TextureCoords[i], t.Normals[i]}]
+			if !ok {
+				continue
+			}
+
+			// make the tangent perpendicular to the normal
+			n := V3{t.Normals[i][0], t.Normals[i][1], t.Normals[i][2]}
+			tangent := sum.tangent.Subtract(n.MultiplyScalar(n.DotProduct(sum.tangent)))
+			if tangent.Length() == 0 {
+				continue
+			}
+			tangent = tangent.Normalize()
+
+			handedness := float32(1)
+			if n.CrossProduct(tangent).DotProduct(sum.bitangent) < 0 {
+				handedness = -1
+			}
+			t.Tangents[i] = V4{tangent[0], tangent[1], tangent[2], handedness}
+		}
+	}
+}
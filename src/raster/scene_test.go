@@ -0,0 +1,184 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"obj"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "matrix"
+)
+
+func TestGenerateTangents(t *testing.T) {
+	// a quad in the xy plane, the second copy has its texture mirrored horizontally
+	quad := func(u0, u1 float32) []Triangle {
+		normals := [3]V4{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+		return []Triangle{
+			{
+				Vertices:      [3]V4{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}},
+				TextureCoords: [3]V4{{u0, 0}, {u1, 0}, {u1, 1}},
+				Normals:       normals,
+			},
+			{
+				Vertices:      [3]V4{{0, 0, 0}, {1, 1, 0}, {0, 1, 0}},
+				TextureCoords: [3]V4{{u0, 0}, {u1, 1}, {u0, 1}},
+				Normals:       normals,
+			},
+		}
+	}
+
+	cases := []struct {
+		triangles []Triangle
+		expected  V4
+	}{
+		{quad(0, 1), V4{1, 0, 0, 1}},
+		{quad(1, 0), V4{-1, 0, 0, -1}},
+	}
+	for _, c := range cases {
+		generateTangents(c.triangles)
+		for _, triangle := range c.triangles {
+			for _, tangent := range triangle.Tangents {
+				if !closeV4(tangent, c.expected) {
+					t.Errorf("tangent = %v, expected %v", tangent, c.expected)
+				}
+			}
+		}
+	}
+
+	// without texture coordinates there is no tangent
+	triangles := quad(0, 0)
+	generateTangents(triangles)
+	if triangles[0].Tangents[0] != (V4{}) {
+		t.Errorf("tangent without texture coordinates = %v", triangles[0].Tangents[0])
+	}
+}
+
+func TestNewMaterialBumpMaps(t *testing.T) {
+	height := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	normals := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	textures := map[textureKey]*Texture{
+		{height, false}:  NewTexture(height),
+		{normals, false}: NewTexture(normals),
+	}
+
+	// map_bump and bump are height maps, only norm holds tangent-space normals
+	for _, m := range []obj.Material{{Name: "a", MapBump: height}, {Name: "b", Bump: height}} {
+		material := newMaterial(m, textures)
+		if material.BumpMap != textures[textureKey{height, false}] || material.NormalMap != nil {
+			t.Errorf("%s: bump map = %v, normal map = %v", m.Name, material.BumpMap, material.NormalMap)
+		}
+	}
+	material := newMaterial(obj.Material{Name: "c", Norm: normals}, textures)
+	if material.NormalMap != textures[textureKey{normals, false}] || material.BumpMap != nil {
+		t.Errorf("norm: bump map = %v, normal map = %v", material.BumpMap, material.NormalMap)
+	}
+}
+
+func TestNewSceneSpecularMap(t *testing.T) {
+	// a quad whose specular map masks out the highlight on its left half
+	dir := t.TempDir()
+	files := map[string]string{
+		"quad.obj": "mtllib quad.mtl\nv -1 -1 0\nv 1 -1 0\nv 1 1 0\nv -1 1 0\nvt 0 0\nvt 1 0\nvt 1 1\nvt 0 1\nvn 0 0 1\n" +
+			"usemtl shiny\nf 1/1/1 2/2/1 3/3/1\nf 1/1/1 3/3/1 4/4/1\n",
+		"quad.mtl": "newmtl shiny\nKd 0 0 0\nKs 1 1 1\nNs 1\nillum 2\nmap_Ks quad_spec.jpg\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if x >= 8 {
+				spec.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			} else {
+				spec.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "quad_spec.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jpeg.Encode(f, spec, &jpeg.Options{Quality: 100})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := obj.Load(filepath.Join(dir, "quad.obj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scene := NewScene(objects)
+	if scene.Triangles[0].Material.SpecularMap == nil {
+		t.Fatal("map_Ks was not loaded")
+	}
+	scene.Lights = []Light{NewDirectionalLight(V4{0, 0, -1}, V3{1, 1, 1})}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	if err := NewRenderer().Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	if c := img.NRGBAAt(27, 32); c.R > 10 {
+		t.Errorf("masked highlight = %v", c)
+	}
+	if c := img.NRGBAAt(37, 32); c.R < 200 {
+		t.Errorf("highlight = %v", c)
+	}
+}
+
+func TestNewScenePointColors(t *testing.T) {
+	scene := NewScene([]obj.Object{{Points: []obj.Point{{Vertex: V4{0, 0, 0, 1}, Color: V4{0.5, 0, 1, 1}}}}})
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	r := NewRenderer()
+	r.PointSize = 4
+	if err := r.Render(img, scene, NewCamera()); err != nil {
+		t.Fatal(err)
+	}
+	// the sRGB vertex color comes back out unchanged
+	if c := img.NRGBAAt(32, 32); c.R < 127 || c.R > 128 || c.G != 0 || c.B != 255 {
+		t.Errorf("point = %v, expected about {128 0 255 255}", c)
+	}
+}
+
+func TestNewMaterialAlpha(t *testing.T) {
+	texture := func(alphas ...uint8) image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, len(alphas), 1))
+		for x, a := range alphas {
+			img.SetNRGBA(x, 0, color.NRGBA{255, 255, 255, a})
+		}
+		return img
+	}
+	opaque, mask, translucent := texture(255, 255), texture(0, 255), texture(0, 128)
+	textures := map[textureKey]*Texture{}
+	for _, img := range []image.Image{opaque, mask, translucent} {
+		textures[textureKey{img, true}] = NewSRGBTexture(img.(*image.NRGBA))
+		textures[textureKey{img, false}] = NewTexture(img.(*image.NRGBA))
+	}
+
+	cases := []struct {
+		material    obj.Material
+		cutout      bool
+		transparent bool
+	}{
+		{obj.Material{Name: "opaque", D: 1, MapKd: opaque}, false, false},
+		// all or nothing texture alpha is a mask
+		{obj.Material{Name: "mask", D: 1, MapKd: mask}, true, false},
+		// anything in between is blended
+		{obj.Material{Name: "translucent", D: 1, MapKd: translucent}, false, true},
+		// map_d is always a mask
+		{obj.Material{Name: "map_d", D: 1, MapD: translucent}, true, false},
+		{obj.Material{Name: "see-through", D: 0.5, MapKd: mask}, false, true},
+	}
+	for _, c := range cases {
+		m := newMaterial(c.material, textures)
+		if cutout := m.AlphaCutoff > 0; cutout != c.cutout || m.transparent() != c.transparent {
+			t.Errorf("%s: cutout = %v, transparent = %v", c.material.Name, cutout, m.transparent())
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package raster
+
+import (
+	"math"
+
+	. "matrix"
+)
+
+// Uniforms are the values that stay constant for every vertex and fragment of a draw
+type Uniforms struct {
+	Model               M4
+	View                M4
+	Projection          M4
+	ModelView           M4
+	ModelViewProjection M4
+	// inverse transpose of ModelView, for transforming normals to view space
+	Normal M4
+	// the scene's lights, in view space
+	Lights  []Light
+	Ambient V3
+	// back faces are lit with their normals flipped
+	TwoSided bool
+}
+
+// Vertex is the input to a vertex shader, in model space
+type Vertex struct {
+	Position     V4
+	TextureCoord V4
+	Normal       V4
+	// w is the handedness of the bitangent
+	Tangent V4
+}
+
+// Fragment is the input to a fragment shader
+type Fragment struct {
+	X        int
+	Y        int
+	Depth    float32
+	Varyings []float32
+	// screen-space derivatives of the varyings, from differences within a 2x2 pixel quad
+	DDX []float32
+	DDY []float32
+	// false when the back of the triangle is visible
+	FrontFacing bool
+	Triangle    *Triangle
+	// index of the triangle in the scene
+	PrimitiveID int
+	// fraction of the ambient light that is blocked by nearby geometry, 0 without SSAO
+	AmbientOcclusion float32
+}
+
+// VertexShader transforms a vertex to clip space and fills in the values that
+// will be interpolated across the triangle
+// shaders are called from several goroutines at once
+type VertexShader interface {
+	// number of varyings written for each vertex
+	Varyings() int
+	ShadeVertex(u *Uniforms, v *Vertex, varyings []float32) V4
+}
+
+// FragmentShader computes the RGBA color of a fragment, with components in the range 0-1
+// returning false discards the fragment
+// shaders are called from several goroutines at once
+type FragmentShader interface {
+	ShadeFragment(u *Uniforms, f *Fragment) (V4, bool)
+}
+
+// Surface is a material evaluated at a fragment, everything lighting needs, in view space
+type Surface struct {
+	Position V3
+	Normal   V3
+	// diffuse color
+	Albedo    V3
+	Specular  V3
+	Emissive  V3
+	Ambient   V3
+	Shininess float32
+	Alpha     float32
+	Illum     int
+	Material  *Material
+	// fraction of the ambient light that is blocked
+	AmbientOcclusion float32
+}
+
+// DeferredShader is a fragment shader that can be split in two for deferred shading,
+// ShadeSurface runs for every fragment and fills in the G-buffer, LightSurface runs once per pixel
+// ShadeFragment is expected to give the same result as calling both
+type DeferredShader interface {
+	FragmentShader
+	ShadeSurface(u *Uniforms, f *Fragment) (Surface, bool)
+	LightSurface(u *Uniforms, s *Surface) V4
+}
+
+// GouraudShader lights vertices with the diffuse term of every light and interpolates
+// the color across the triangle, textured triangles use the texture color instead
+type GouraudShader struct {
+	DiffuseColor V3
+}
+
+func NewGouraudShader() *GouraudShader {
+	return &GouraudShader{
+		DiffuseColor: V3{0.4, 0.4, 1},
+	}
+}
+
+func (s *GouraudShader) Varyings() int {
+	return 8
+}
+
+func (s *GouraudShader) ShadeVertex(u *Uniforms, v *Vertex, varyings []float32) V4 {
+	pos := v.Position
+	position := V4{pos[0], pos[1], pos[2], 1}
+
+	norm := v.Normal
+	normal := V4{norm[0], norm[1], norm[2], 0}
+
+	// calculate color (interpolated across triangle)
+	eye := u.ModelView.MultiplyV4(position)
+	eyeNormal := u.Normal.MultiplyV4(normal)
+	// a zero normal from the file gets no diffuse light instead of NaNs
+	n := V3{eyeNormal[0], eyeNormal[1], eyeNormal[2]}
+	if l := n.Length(); l > 0 {
+		n = n.DivideScalar(l)
+	}
+	c, back := V3{}, V3{}
+	for i := range u.Lights {
+		light, radiance := u.Lights[i].illuminate(V3{eye[0], eye[1], eye[2]}, n)
+		c = c.Add(radiance.MultiplyScalar(max(0, n.DotProduct(light))))
+		if u.TwoSided {
+			// the back of the surface faces the other way
+			_, radiance = u.Lights[i].illuminate(V3{eye[0], eye[1], eye[2]}, n.Negate())
+			back = back.Add(radiance.MultiplyScalar(max(0, -n.DotProduct(light))))
+		}
+	}
+	c = c.Multiply(s.DiffuseColor)
+	back = back.Multiply(s.DiffuseColor)
+
+	tex := v.TextureCoord
+	varyings[0] = c[0]
+	varyings[1] = c[1]
+	varyings[2] = c[2]
+	varyings[3] = tex[0]
+	varyings[4] = tex[1]
+	varyings[5] = back[0]
+	varyings[6] = back[1]
+	varyings[7] = back[2]
+
+	return u.ModelViewProjection.MultiplyV4(position)
+}
+
+func (s *GouraudShader) ShadeFragment(u *Uniforms, f *Fragment) (V4, bool) {
+	interp := f.Varyings
+	m := f.Triangle.Material
+	if m == nil || m.DiffuseMap == nil {
+		if u.TwoSided && !f.FrontFacing {
+			return V4{interp[5], interp[6], interp[7], 1}, true
+		}
+		return V4{interp[0], interp[1], interp[2], 1}, true
+	}
+	return m.Sampler.SampleGrad(m.DiffuseMap, interp[3], interp[4], f.DDX[3], f.DDX[4], f.DDY[3], f.DDY[4]), true
+}
+
+// PhongShader lights every fragment with the Blinn-Phong model using the scene's lights,
+// the interpolated normal and the triangle's material
+type PhongShader struct{}
+
+func NewPhongShader() *PhongShader {
+	return &PhongShader{}
+}
+
+func (s *PhongShader) Varyings() int {
+	return 12
+}
+
+func (s *PhongShader) ShadeVertex(u *Uniforms, v *Vertex, varyings []float32) V4 {
+	pos := v.Position
+	position := V4{pos[0], pos[1], pos[2], 1}
+
+	norm := v.Normal
+	normal := V4{norm[0], norm[1], norm[2], 0}
+
+	// lighting is done in view space, where the eye is at the origin
+	eye := u.ModelView.MultiplyV4(position)
+	eyeNormal := u.Normal.MultiplyV4(normal)
+	// tangents lie in the surface, so they transform like positions
+	tan := v.Tangent
+	eyeTangent := u.ModelView.MultiplyV4(V4{tan[0], tan[1], tan[2], 0})
+
+	tex := v.TextureCoord
+	varyings[0] = eye[0]
+	varyings[1] = eye[1]
+	varyings[2] = eye[2]
+	varyings[3] = eyeNormal[0]
+	varyings[4] = eyeNormal[1]
+	varyings[5] = eyeNormal[2]
+	varyings[6] = tex[0]
+	varyings[7] = tex[1]
+	varyings[8] = eyeTangent[0]
+	varyings[9] = eyeTangent[1]
+	varyings[10] = eyeTangent[2]
+	varyings[11] = tan[3]
+
+	return u.ModelViewProjection.MultiplyV4(position)
+}
+
+// http://paulbourke.net/dataformats/mtl/
+func (s *PhongShader) ShadeFragment(u *Uniforms, f *Fragment) (V4, bool) {
+	surface, _ := s.ShadeSurface(u, f)
+	return s.LightSurface(u, &surface), true
+}
+
+// ShadeSurface samples the material's textures and perturbs the normal
+func (s *PhongShader) ShadeSurface(u *Uniforms, f *Fragment) (Surface, bool) {
+	interp := f.Varyings
+	m := f.Triangle.Material
+	if m == nil {
+		m = &DefaultMaterial
+	}
+
+	sample := func(t *Texture) V4 {
+		return m.Sampler.SampleGrad(t, interp[6], interp[7], f.DDX[6], f.DDX[7], f.DDY[6], f.DDY[7])
+	}
+
+	diffuse := m.Diffuse
+	alpha := float32(1)
+	if m.DiffuseMap != nil {
+		tex := sample(m.DiffuseMap)
+		diffuse = diffuse.Multiply(V3{tex[0], tex[1], tex[2]})
+		alpha = tex[3]
+	}
+	if m.AlphaMap != nil {
+		alpha *= sample(m.AlphaMap)[0]
+	}
+	alpha *= 1 - m.Transparency
+	if m.Illum == 0 {
+		return Surface{Albedo: diffuse, Alpha: alpha, Material: m}, true
+	}
+
+	emissive := m.Emissive
+	if m.EmissiveMap != nil {
+		tex := sample(m.EmissiveMap)
+		emissive = emissive.Multiply(V3{tex[0], tex[1], tex[2]})
+	}
+	specularColor := m.Specular
+	shininess := m.Shininess
+	if m.Illum >= 2 {
+		if m.SpecularMap != nil {
+			tex := sample(m.SpecularMap)
+			specularColor = specularColor.Multiply(V3{tex[0], tex[1], tex[2]})
+		}
+		if m.ShininessMap != nil {
+			shininess *= sample(m.ShininessMap)[0]
+		}
+	}
+
+	return Surface{
+		Position:  V3{interp[0], interp[1], interp[2]},
+		Normal:    perturbNormal(m, f, u.TwoSided),
+		Albedo:    diffuse,
+		Specular:  specularColor,
+		Emissive:  emissive,
+		Ambient:   m.Ambient,
+		Shininess: shininess,
+		Alpha:     alpha,
+		Illum:     m.Illum,
+		Material:  m,
+
+		AmbientOcclusion: f.AmbientOcclusion,
+	}, true
+}
+
+// LightSurface adds up the ambient, diffuse and specular light reflected by a surface
+func (s *PhongShader) LightSurface(u *Uniforms, surface *Surface) V4 {
+	if surface.Illum == 0 {
+		return V4{surface.Albedo[0], surface.Albedo[1], surface.Albedo[2], surface.Alpha}
+	}
+
+	position, normal := surface.Position, surface.Normal
+	view := position.Negate().Normalize()
+
+	c := surface.Emissive.Add(surface.Ambient.Multiply(u.Ambient).MultiplyScalar(1 - surface.AmbientOcclusion))
+	for i := range u.Lights {
+		light, radiance := u.Lights[i].illuminate(position, normal)
+		nDotL := normal.DotProduct(light)
+		if nDotL <= 0 {
+			continue
+		}
+		c = c.Add(surface.Albedo.Multiply(radiance).MultiplyScalar(nDotL))
+
+		if surface.Illum >= 2 {
+			// the half vector between the light and the direction towards the eye
+			half := light.Add(view).Normalize()
+			nDotH := max(0, normal.DotProduct(half))
+			specular := float32(math.Pow(float64(nDotH), float64(surface.Shininess)))
+			c = c.Add(surface.Specular.Multiply(radiance).MultiplyScalar(specular))
+		}
+	}
+
+	return V4{c[0], c[1], c[2], surface.Alpha}
+}
+
+// perturbNormal returns the interpolated normal of a PhongShader fragment, tilted by the normal or bump map of the material
+// http://www.thetenthplanet.de/archives/1180
+func perturbNormal(m *Material, f *Fragment, twoSided bool) V3 {
+	interp := f.Varyings
+	normal := V3{interp[3], interp[4], interp[5]}
+	if l := normal.Length(); l > 0 {
+		normal = normal.DivideScalar(l)
+	} else {
+		// zero normals in the file, or opposite ones interpolated across the triangle
+		normal = faceNormal(f)
+	}
+	if twoSided && !f.FrontFacing {
+		// the back of a surface faces the other way
+		normal = normal.Negate()
+	}
+	if m.NormalMap == nil && m.BumpMap == nil {
+		return normal
+	}
+
+	// rebuild an orthonormal tangent frame, interpolation skews it
+	tangent := V3{interp[8], interp[9], interp[10]}
+	tangent = tangent.Subtract(normal.MultiplyScalar(normal.DotProduct(tangent)))
+	if tangent.Length() == 0 {
+		// no texture coordinates to derive a tangent from
+		return normal
+	}
+	tangent = tangent.Normalize()
+	bitangent := normal.CrossProduct(tangent)
+	if interp[11] < 0 {
+		bitangent = bitangent.Negate()
+	}
+
+	u, v := interp[6], interp[7]
+	dudx, dvdx, dudy, dvdy := f.DDX[6], f.DDX[7], f.DDY[6], f.DDY[7]
+
+	var n V3
+	if m.NormalMap != nil {
+		c := m.Sampler.SampleGrad(m.NormalMap, u, v, dudx, dvdx, dudy, dvdy)
+		n = V3{c[0]*2 - 1, c[1]*2 - 1, c[2]*2 - 1}
+	} else {
+		// slope of the height field between neighbouring texels
+		du := 1 / float32(m.BumpMap.Levels[0].Rect.Dx())
+		dv := 1 / float32(m.BumpMap.Levels[0].Rect.Dy())
+		h := height(m.Sampler.SampleGrad(m.BumpMap, u, v, dudx, dvdx, dudy, dvdy))
+		hu := height(m.Sampler.SampleGrad(m.BumpMap, u+du, v, dudx, dvdx, dudy, dvdy))
+		hv := height(m.Sampler.SampleGrad(m.BumpMap, u, v+dv, dudx, dvdx, dudy, dvdy))
+		scale := m.BumpScale
+		if scale == 0 {
+			scale = 1
+		}
+		n = V3{(h - hu) * scale, (h - hv) * scale, 1}
+	}
+
+	perturbed := tangent.MultiplyScalar(n[0]).Add(bitangent.MultiplyScalar(n[1])).Add(normal.MultiplyScalar(n[2]))
+	if l := perturbed.Length(); l > 0 {
+		return perturbed.DivideScalar(l)
+	}
+	return normal
+}
+
+// faceNormal returns the normal of the plane of a PhongShader fragment's triangle, from the derivatives of its position,
+// pointing to the side its winding makes the front
+func faceNormal(f *Fragment) V3 {
+	position := V3{f.Varyings[0], f.Varyings[1], f.Varyings[2]}
+	toEye := position.Negate()
+	normal := toEye
+	if len(f.DDX) >= 3 && len(f.DDY) >= 3 {
+		if n := (V3{f.DDX[0], f.DDX[1], f.DDX[2]}).CrossProduct(V3{f.DDY[0], f.DDY[1], f.DDY[2]}); n.Length() > 0 {
+			normal = n
+			if normal.DotProduct(toEye) < 0 {
+				normal = normal.Negate()
+			}
+		}
+	}
+	if l := normal.Length(); l > 0 {
+		normal = normal.DivideScalar(l)
+	}
+	if !f.FrontFacing {
+		return normal.Negate()
+	}
+	return normal
+}
+
+// height of a bump map texel, the average of the channels so that color images work too
+func height(c V4) float32 {
+	return (c[0] + c[1] + c[2]) / 3
+}
@@ -0,0 +1,204 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	. "matrix"
+)
+
+func TestPhongShader(t *testing.T) {
+	shader := NewPhongShader()
+	u := &Uniforms{
+		Lights:  []Light{NewDirectionalLight(V4{0, 0, -1}, V3{1, 1, 1})},
+		Ambient: V3{0.5, 0.5, 0.5},
+	}
+
+	material := &Material{
+		Ambient:   V3{0.2, 0, 0},
+		Diffuse:   V3{0, 0.5, 0},
+		Specular:  V3{0, 0, 1},
+		Emissive:  V3{0.1, 0, 0},
+		Shininess: 10,
+	}
+
+	shade := func(normal V3) V4 {
+		// fragment straight in front of the eye
+		f := &Fragment{
+			Varyings:    []float32{0, 0, -5, normal[0], normal[1], normal[2], 0, 0},
+			FrontFacing: true,
+			Triangle:    &Triangle{Material: material},
+		}
+		c, ok := shader.ShadeFragment(u, f)
+		if !ok {
+			t.Fatal("fragment discarded")
+		}
+		return c
+	}
+
+	cases := []struct {
+		illum    int
+		normal   V3
+		expected V4
+	}{
+		// unlit
+		{0, V3{0, 0, 1}, V4{0, 0.5, 0, 1}},
+		// facing away from the light only gets ambient and emissive
+		{1, V3{0, 0, -1}, V4{0.2, 0, 0, 1}},
+		{1, V3{0, 0, 1}, V4{0.2, 0.5, 0, 1}},
+		// the light, eye and normal line up for the brightest highlight
+		{2, V3{0, 0, 1}, V4{0.2, 0.5, 1, 1}},
+	}
+	for _, c := range cases {
+		material.Illum = c.illum
+		if actual := shade(c.normal); !closeV4(actual, c.expected) {
+			t.Errorf("illum %d with normal %v = %v, expected %v", c.illum, c.normal, actual, c.expected)
+		}
+	}
+}
+
+func TestLightIlluminate(t *testing.T) {
+	point := NewPointLight(V4{0, 0, 2}, V3{1, 0.5, 0})
+	point.Quadratic = 1
+	direction, radiance := point.illuminate(V3{}, V3{0, 0, 1})
+	if !closeV4(V4{direction[0], direction[1], direction[2]}, V4{0, 0, 1}) {
+		t.Errorf("point light direction = %v", direction)
+	}
+	if !closeV4(V4{radiance[0], radiance[1], radiance[2]}, V4{0.2, 0.1, 0}) {
+		t.Errorf("point light radiance = %v", radiance)
+	}
+
+	spot := NewSpotLight(V4{0, 0, 1}, V4{0, 0, -1}, 0.1, 0.2, V3{1, 1, 1})
+	if _, radiance := spot.illuminate(V3{0, 0, 0}, V3{0, 0, 1}); !closeV4(V4{radiance[0]}, V4{1}) {
+		t.Errorf("inside the inner cone = %v", radiance)
+	}
+	if _, radiance := spot.illuminate(V3{0.15, 0, 0}, V3{0, 0, 1}); !(radiance[0] > 0 && radiance[0] < 1) {
+		t.Errorf("between the cones = %v", radiance)
+	}
+	if _, radiance := spot.illuminate(V3{1, 0, 0}, V3{0, 0, 1}); radiance[0] != 0 {
+		t.Errorf("outside the outer cone = %v", radiance)
+	}
+}
+
+func TestTwoSided(t *testing.T) {
+	u := &Uniforms{
+		Lights:              []Light{NewDirectionalLight(V4{0, 0, -1}, V3{1, 1, 1})},
+		ModelView:           IdentityM4,
+		ModelViewProjection: IdentityM4,
+		Normal:              IdentityM4,
+	}
+	material := &Material{Diffuse: V3{1, 1, 1}, Illum: 1}
+	gouraud := &GouraudShader{DiffuseColor: V3{1, 1, 1}}
+
+	// the back of a surface whose normal points away from the eye and the light
+	shade := func(shader FragmentShader, varyings []float32) V4 {
+		f := &Fragment{Varyings: varyings, FrontFacing: false, Triangle: &Triangle{Material: material}}
+		c, _ := shader.ShadeFragment(u, f)
+		return c
+	}
+	for _, twoSided := range []bool{false, true} {
+		u.TwoSided = twoSided
+		expected := V4{0, 0, 0, 1}
+		if twoSided {
+			expected = V4{1, 1, 1, 1}
+		}
+
+		if c := shade(NewPhongShader(), []float32{0, 0, -5, 0, 0, -1, 0, 0}); !closeV4(c, expected) {
+			t.Errorf("PhongShader two-sided %v = %v, expected %v", twoSided, c, expected)
+		}
+
+		varyings := make([]float32, gouraud.Varyings())
+		gouraud.ShadeVertex(u, &Vertex{Position: V4{0, 0, -5}, Normal: V4{0, 0, -1}}, varyings)
+		if c := shade(gouraud, varyings); !closeV4(c, expected) {
+			t.Errorf("GouraudShader two-sided %v = %v, expected %v", twoSided, c, expected)
+		}
+	}
+}
+
+func TestPhongShaderDegenerate(t *testing.T) {
+	u := &Uniforms{
+		Lights: []Light{
+			// exactly on the surface
+			NewPointLight(V4{0, 0, -5}, V3{1, 1, 1}),
+			NewDirectionalLight(V4{0, 0, -1}, V3{1, 1, 1}),
+		},
+	}
+	material := &Material{Diffuse: V3{1, 1, 1}, Illum: 1}
+	f := &Fragment{
+		// a zero normal on a triangle facing the eye
+		Varyings:    []float32{0, 0, -5, 0, 0, 0, 0, 0},
+		FrontFacing: true,
+		DDX:         []float32{1, 0, 0, 0, 0, 0, 0, 0},
+		DDY:         []float32{0, -1, 0, 0, 0, 0, 0, 0},
+		Triangle:    &Triangle{Material: material},
+	}
+	c, _ := NewPhongShader().ShadeFragment(u, f)
+	if expected := (V4{1, 1, 1, 1}); !closeV4(c, expected) {
+		t.Errorf("ShadeFragment = %v, expected %v", c, expected)
+	}
+
+	gouraud := NewGouraudShader()
+	varyings := make([]float32, gouraud.Varyings())
+	gouraud.ShadeVertex(&Uniforms{Lights: u.Lights, ModelView: IdentityM4, Normal: IdentityM4}, &Vertex{Position: V4{0, 0, -5}}, varyings)
+	for _, v := range varyings {
+		if v != v {
+			t.Fatalf("GouraudShader varyings = %v", varyings)
+		}
+	}
+}
+
+func solidTexture(c color.NRGBA) *Texture {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, c)
+	return NewTexture(img)
+}
+
+func TestPhongShaderMaps(t *testing.T) {
+	u := &Uniforms{
+		Lights: []Light{NewDirectionalLight(V4{0, 0, -1}, V3{1, 1, 1})},
+	}
+	material := &Material{
+		Specular:     V3{1, 1, 1},
+		Emissive:     V3{1, 1, 1},
+		Shininess:    10,
+		Illum:        2,
+		SpecularMap:  solidTexture(color.NRGBA{0, 0, 255, 255}),
+		EmissiveMap:  solidTexture(color.NRGBA{255, 0, 0, 255}),
+		ShininessMap: solidTexture(color.NRGBA{0, 0, 0, 255}),
+		AlphaMap:     solidTexture(color.NRGBA{0, 0, 0, 255}),
+		Sampler:      DefaultSampler,
+	}
+	f := &Fragment{
+		Varyings:    []float32{0, 0, -5, 0, 0, 1, 0, 0, 0, 0, 0, 0},
+		FrontFacing: true,
+		DDX:         make([]float32, 12),
+		DDY:         make([]float32, 12),
+		Triangle:    &Triangle{Material: material},
+	}
+	c, _ := NewPhongShader().ShadeFragment(u, f)
+	if expected := (V4{1, 0, 1, 0}); !closeV4(c, expected) {
+		t.Errorf("ShadeFragment = %v, expected %v", c, expected)
+	}
+}
+
+func TestPerturbNormalBumpMap(t *testing.T) {
+	// heights rise along u
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		img.SetNRGBA(0, y, color.NRGBA{0, 0, 0, 255})
+		img.SetNRGBA(1, y, color.NRGBA{255, 255, 255, 255})
+	}
+	// a hand-built material without a BumpScale
+	material := &Material{BumpMap: NewTexture(img), Sampler: DefaultSampler}
+	f := &Fragment{
+		Varyings:    []float32{0, 0, -5, 0, 0, 1, 0.25, 0.25, 1, 0, 0, 1},
+		FrontFacing: true,
+		DDX:         make([]float32, 12),
+		DDY:         make([]float32, 12),
+	}
+	n := perturbNormal(material, f, false)
+	if expected := (V3{-1, 0, 1}).Normalize(); !closeV4(V4{n[0], n[1], n[2]}, V4{expected[0], expected[1], expected[2]}) {
+		t.Errorf("perturbNormal = %v, expected %v", n, expected)
+	}
+}
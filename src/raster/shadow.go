@@ -0,0 +1,242 @@
+package raster
+
+import (
+	"errors"
+	"math"
+
+	. "matrix"
+)
+
+const (
+	defaultShadowMapSize = 1024
+	defaultShadowBias    = 0.005
+)
+
+// shadowMap is the depth buffer of a scene rendered from a light, as linear distances from the light,
+// along its direction for directional lights and along the rays from its position for spot lights
+type shadowMap struct {
+	size  int
+	depth []float32
+	// transform view space positions of the main pass to the light's view space and from there to its clip space
+	view        M4
+	projection  M4
+	perspective bool
+	// view space size of a texel at a clip space w of 1
+	texelScale float32
+	bias       float32
+	filter     int
+}
+
+// renderShadowMaps draws the depth of the scene from every shadow-casting light
+// and collects the lights in view space for the main pass
+func (r *Renderer) renderShadowMaps(scene *Scene, view M4) error {
+	if len(r.shadowMaps) < len(scene.Lights) {
+		r.shadowMaps = make([]shadowMap, len(scene.Lights))
+	}
+
+	r.lights = r.lights[:0]
+	var center V3
+	var radius float32
+	for i, l := range scene.Lights {
+		viewLight := l.transform(view)
+		if !l.CastShadows || l.Type == LightPoint {
+			r.lights = append(r.lights, viewLight)
+			continue
+		}
+
+		if radius == 0 {
+			center, radius = scene.bounds()
+		}
+		lightView, lightProjection := l.shadowProjection(center, radius)
+
+		inverseView, ok := view.Inverse()
+		if !ok {
+			return errors.New("failed to invert transform")
+		}
+
+		if r.shadowRenderer == nil {
+			shader := depthShader{}
+			r.shadowRenderer = &Renderer{VertexShader: shader, FragmentShader: shader, depthOnly: true}
+		}
+		sr := r.shadowRenderer
+		sr.TileSize = r.TileSize
+		sr.Workers = r.Workers
+		sr.Samples = 1
+		// open meshes need both sides to cast shadows
+		sr.CullMode = CullNone
+
+		size := l.ShadowMapSize
+		if size <= 0 {
+			size = defaultShadowMapSize
+		}
+		sr.fb.resize(size, size, 1)
+		sr.fb.clear(V4{})
+		if err := sr.draw(scene, lightView, lightProjection); err != nil {
+			return err
+		}
+
+		// perspective depth crowds towards 1 away from the near plane, a constant bias there is
+		// worth a different distance everywhere, so the comparison is done on linear depth
+		sm := &r.shadowMaps[i]
+		sm.size = size
+		sm.depth = sm.depth[:0]
+		sm.perspective = l.Type != LightDirectional
+		for j, d := range sr.fb.depth {
+			depth := linearDepth(lightProjection, d)
+			if sm.perspective {
+				// the distance along the ray through the texel center changes slowest across a surface facing the light
+				x := (float32(j%size)+0.5)/float32(size)*2 - 1
+				y := 1 - (float32(j/size)+0.5)/float32(size)*2
+				depth = V3{x * depth / lightProjection[0], y * depth / lightProjection[5], depth}.Length()
+			}
+			sm.depth = append(sm.depth, depth)
+		}
+		sm.view = lightView.Multiply(inverseView)
+		sm.projection = lightProjection
+		sm.texelScale = 2 / (float32(size) * lightProjection[0])
+		sm.bias = l.ShadowBias
+		sm.filter = l.ShadowFilter
+
+		viewLight.shadow = sm
+		r.lights = append(r.lights, viewLight)
+	}
+	return nil
+}
+
+// shadowProjection returns the view and projection matrices of a light that cover a sphere around the scene
+func (l *Light) shadowProjection(center V3, radius float32) (M4, M4) {
+	direction := V3{l.Direction[0], l.Direction[1], l.Direction[2]}.Normalize()
+
+	if l.Type == LightDirectional {
+		eye := center.Subtract(direction.MultiplyScalar(2 * radius))
+		view := lookAt(eye, direction)
+		return view, IdentityM4.ProjectOrthographic(-radius, radius, -radius, radius, radius, 3*radius)
+	}
+
+	eye := V3{l.Position[0], l.Position[1], l.Position[2]}
+	distance := eye.Distance(center)
+	// inside the scene the near plane can't enclose it, keep it far enough from the light for usable precision
+	near := max(radius/100, distance-radius)
+	far := distance + radius
+	fov := min(2*l.OuterCone, math.Pi*0.99)
+	return lookAt(eye, direction), IdentityM4.ProjectPerspective(fov, 1, near, far)
+}
+
+// linearDepth returns the distance in front of the camera of a normalized depth
+func linearDepth(projection M4, depth float32) float32 {
+	// solve depth = (p[10]*z + p[14]) / (p[11]*z + p[15]) for the view space z
+	z := (projection[14] - depth*projection[15]) / (depth*projection[11] - projection[10])
+	return -z
+}
+
+// lookAt returns a view matrix at eye looking along direction
+func lookAt(eye, direction V3) M4 {
+	up := V3{0, 1, 0}
+	if abs(direction.DotProduct(up)) > 0.99 {
+		up = V3{1, 0, 0}
+	}
+	right := direction.CrossProduct(up).Normalize()
+	up = right.CrossProduct(direction)
+
+	return M4{
+		right[0], up[0], -direction[0], 0,
+		right[1], up[1], -direction[1], 0,
+		right[2], up[2], -direction[2], 0,
+		-right.DotProduct(eye), -up.DotProduct(eye), direction.DotProduct(eye), 1,
+	}
+}
+
+// visibility returns the fraction of the filter footprint around a view space position that the light reaches,
+// normal and light are the surface normal and the direction towards the light
+// http://http.developer.nvidia.com/GPUGems/gpugems_ch11.html
+func (s *shadowMap) visibility(position, normal, light V3) float32 {
+	clip := s.projection.MultiplyV4(s.view.MultiplyV4(V4{position[0], position[1], position[2], 1}))
+	if clip[3] <= 0 {
+		return 1
+	}
+
+	// the filter compares against texels up to filter+1 texels away, which a surface tilted away from
+	// the light crosses at different depths, so move the position off the surface until it clears them
+	// http://www.dissidentlogic.com/old/images/NormalOffsetShadows/GDC_Poster_NormalOffset.png
+	cos := clamp(normal.DotProduct(light))
+	sin := float32(math.Sqrt(float64(1 - cos*cos)))
+	offset := s.texelScale * clip[3] * float32(s.filter+1) * sin
+	position = position.Add(normal.MultiplyScalar(offset))
+	view := s.view.MultiplyV4(V4{position[0], position[1], position[2], 1})
+	clip = s.projection.MultiplyV4(view)
+	if clip[3] <= 0 {
+		return 1
+	}
+
+	x := clip[0] / clip[3]
+	y := clip[1] / clip[3]
+	if clip[2]/clip[3] > 1 {
+		// beyond the far plane of the light
+		return 1
+	}
+	z := -view[2]
+	if s.perspective {
+		z = V3{view[0], view[1], view[2]}.Length()
+	}
+	z -= s.bias
+
+	// same pixel mapping as setupTriangle
+	fsize := float32(s.size)
+	px := int(math.Floor(float64((x + 1) / 2 * fsize)))
+	py := int(math.Floor(float64((1 - y) / 2 * fsize)))
+
+	lit, total := 0, 0
+	for dy := -s.filter; dy <= s.filter; dy++ {
+		for dx := -s.filter; dx <= s.filter; dx++ {
+			tx, ty := px+dx, py+dy
+			total++
+			if tx < 0 || ty < 0 || tx >= s.size || ty >= s.size || z <= s.depth[ty*s.size+tx] {
+				lit++
+			}
+		}
+	}
+	return float32(lit) / float32(total)
+}
+
+// depthShader only transforms positions, for passes that need nothing but the depth buffer
+// it still computes the alpha of cutout materials so that their holes don't cast shadows
+type depthShader struct{}
+
+func (depthShader) Varyings() int {
+	return 2
+}
+
+func (depthShader) ShadeVertex(u *Uniforms, v *Vertex, varyings []float32) V4 {
+	pos := v.Position
+	varyings[0] = v.TextureCoord[0]
+	varyings[1] = v.TextureCoord[1]
+	return u.ModelViewProjection.MultiplyV4(V4{pos[0], pos[1], pos[2], 1})
+}
+
+func (depthShader) ShadeFragment(u *Uniforms, f *Fragment) (V4, bool) {
+	m := f.Triangle.Material
+	if m == nil || m.AlphaCutoff == 0 {
+		return V4{0, 0, 0, 1}, true
+	}
+
+	return V4{0, 0, 0, cutoutAlpha(m, f.Varyings[0], f.Varyings[1], f.DDX, f.DDY)}, true
+}
+
+// cutoutAlpha samples the alpha of a material at a texture coordinate, ddx and ddy start with the derivatives of u and v
+func cutoutAlpha(m *Material, u, v float32, ddx, ddy []float32) float32 {
+	alpha := 1 - m.Transparency
+	if m.DiffuseMap != nil {
+		alpha *= m.Sampler.SampleGrad(m.DiffuseMap, u, v, ddx[0], ddx[1], ddy[0], ddy[1])[3]
+	}
+	if m.AlphaMap != nil {
+		alpha *= m.Sampler.SampleGrad(m.AlphaMap, u, v, ddx[0], ddx[1], ddy[0], ddy[1])[0]
+	}
+	return alpha
+}
+
+func abs(f float32) float32 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
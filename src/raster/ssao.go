@@ -0,0 +1,286 @@
+package raster
+
+import (
+	"errors"
+	"image"
+	"math"
+	"math/rand"
+
+	. "matrix"
+)
+
+const (
+	ssaoKernelSize = 16
+	// the random rotations repeat in tiles of this size, which the blur averages out
+	ssaoNoiseSize = 4
+	// fraction of the radius a surface has to be in front of a sample to occlude it, against self-occlusion
+	ssaoBias = 0.025
+
+	defaultSSAORadius   = 0.5
+	defaultSSAOStrength = 1
+)
+
+// ssaoKernel holds sample offsets in a unit hemisphere around +z, clustered towards the center,
+// and ssaoNoise random rotations around the normal
+var ssaoKernel, ssaoNoise = newSSAOKernel()
+
+func newSSAOKernel() ([ssaoKernelSize]V3, [ssaoNoiseSize * ssaoNoiseSize]V3) {
+	random := rand.New(rand.NewSource(1))
+	var kernel [ssaoKernelSize]V3
+	for i := range kernel {
+		sample := V3{random.Float32()*2 - 1, random.Float32()*2 - 1, random.Float32()}.Normalize()
+		scale := float32(i) / ssaoKernelSize
+		scale = 0.1 + 0.9*scale*scale
+		kernel[i] = sample.MultiplyScalar(scale)
+	}
+	var noise [ssaoNoiseSize * ssaoNoiseSize]V3
+	for i := range noise {
+		noise[i] = V3{random.Float32()*2 - 1, random.Float32()*2 - 1, 0}
+	}
+	return kernel, noise
+}
+
+// renderAmbientOcclusion estimates how much of the hemisphere above every pixel is blocked by nearby geometry,
+// which is removed from the ambient light of the main pass
+// forward shading needs the occlusion while the main pass shades fragments, before it has a depth buffer,
+// so the depth of the scene is drawn in a prepass, deferred shading skips it and uses the depth of the
+// G-buffer in gBufferAmbientOcclusion before lighting
+// http://john-chapman-graphics.blogspot.com/2013/01/ssao-tutorial.html
+func (r *Renderer) renderAmbientOcclusion(scene *Scene, view, projection M4, width, height int) error {
+	r.ao = r.ao[:0]
+	if !r.SSAO && r.DebugView != DebugAmbientOcclusion {
+		return nil
+	}
+	if r.Deferred && r.DebugView == DebugNone {
+		return nil
+	}
+
+	if r.depthRenderer == nil {
+		shader := depthShader{}
+		r.depthRenderer = &Renderer{VertexShader: shader, FragmentShader: shader, depthOnly: true}
+	}
+	dr := r.depthRenderer
+	dr.TileSize = r.TileSize
+	dr.Workers = r.Workers
+	dr.Samples = 1
+	dr.ClipFrustum = r.ClipFrustum
+	dr.CullMode = r.CullMode
+	dr.FrontFace = r.FrontFace
+	dr.fb.resize(width, height, 1)
+	dr.fb.clear(V4{})
+	if err := dr.draw(scene, view, projection); err != nil {
+		return err
+	}
+	return r.ambientOcclusion(dr.fb.depth, projection, width, height)
+}
+
+// gBufferAmbientOcclusion computes SSAO from the depth of the G-buffer, the samples of a pattern are centered
+// on the pixel so their average is the depth at the center inside a triangle
+func (r *Renderer) gBufferAmbientOcclusion() error {
+	fb := &r.fb
+	if len(r.aoDepth) != fb.width*fb.height {
+		r.aoDepth = make([]float32, fb.width*fb.height)
+	}
+	r.parallelRows(fb.height, func(py int) {
+		for px := 0; px < fb.width; px++ {
+			sum, count := float32(0), 0
+			base := (py*fb.width + px) * fb.samples
+			for _, d := range fb.depth[base : base+fb.samples] {
+				if d < 1 {
+					sum += d
+					count++
+				}
+			}
+			if count == 0 {
+				r.aoDepth[py*fb.width+px] = 1
+			} else {
+				r.aoDepth[py*fb.width+px] = sum / float32(count)
+			}
+		}
+	})
+	return r.ambientOcclusion(r.aoDepth, r.uniforms.Projection, fb.width, fb.height)
+}
+
+// ambientOcclusion fills r.ao from a depth buffer with one depth per pixel center
+func (r *Renderer) ambientOcclusion(depth []float32, projection M4, width, height int) error {
+	inverseProjection, ok := projection.Inverse()
+	if !ok {
+		return errors.New("failed to invert projection")
+	}
+
+	// view space positions of the pixel centers, the background is marked with a w of 0
+	n := width * height
+	if len(r.aoBlur) != n {
+		r.aoBlur = make([]float32, n)
+		r.aoPositions = make([]V4, n)
+	}
+	if cap(r.ao) < n {
+		r.ao = make([]float32, n)
+	}
+	r.ao = r.ao[:n]
+	positions := r.aoPositions
+	r.parallelRows(height, func(py int) {
+		for px := 0; px < width; px++ {
+			depth := depth[py*width+px]
+			if depth >= 1 {
+				positions[py*width+px] = V4{}
+				continue
+			}
+			x := (float32(px)+0.5)/float32(width)*2 - 1
+			y := 1 - (float32(py)+0.5)/float32(height)*2
+			p := inverseProjection.MultiplyV4(V4{x, y, depth, 1})
+			positions[py*width+px] = V4{p[0] / p[3], p[1] / p[3], p[2] / p[3], 1}
+		}
+	})
+
+	// view space z of the visible surface at a point of the screen, the depth buffer is interpolated between
+	// pixel centers since depth is linear in screen space across a triangle
+	surfaceZ := func(x, y float32) (float32, bool) {
+		fx, fy := x-0.5, y-0.5
+		x0 := maxInt(0, minInt(width-1, int(math.Floor(float64(fx)))))
+		y0 := maxInt(0, minInt(height-1, int(math.Floor(float64(fy)))))
+		x1, y1 := minInt(width-1, x0+1), minInt(height-1, y0+1)
+		tx, ty := clamp(fx-float32(x0)), clamp(fy-float32(y0))
+
+		d00, d10 := depth[y0*width+x0], depth[y0*width+x1]
+		d01, d11 := depth[y1*width+x0], depth[y1*width+x1]
+		var d float32
+		if d00 < 1 && d10 < 1 && d01 < 1 && d11 < 1 {
+			d = (d00*(1-tx)+d10*tx)*(1-ty) + (d01*(1-tx)+d11*tx)*ty
+		} else {
+			// an edge of the geometry, don't mix it with the background
+			d = depth[minInt(height-1, int(y))*width+minInt(width-1, int(x))]
+			if d >= 1 {
+				return 0, false
+			}
+		}
+		p := inverseProjection.MultiplyV4(V4{x/float32(width)*2 - 1, 1 - y/float32(height)*2, d, 1})
+		return p[2] / p[3], true
+	}
+
+	radius := r.SSAORadius
+	if radius <= 0 {
+		radius = defaultSSAORadius
+	}
+	strength := r.SSAOStrength
+
+	r.parallelRows(height, func(py int) {
+		for px := 0; px < width; px++ {
+			p := positions[py*width+px]
+			if p[3] == 0 {
+				r.aoBlur[py*width+px] = 0
+				continue
+			}
+			position := V3{p[0], p[1], p[2]}
+			normal := reconstructNormal(positions, width, height, px, py)
+
+			// a tangent frame rotated by the noise
+			random := ssaoNoise[(py%ssaoNoiseSize)*ssaoNoiseSize+px%ssaoNoiseSize]
+			tangent := random.Subtract(normal.MultiplyScalar(random.DotProduct(normal)))
+			if tangent.Length() < 1e-6 {
+				tangent = normal.CrossProduct(V3{0, 1, 0})
+				if tangent.Length() < 1e-6 {
+					tangent = normal.CrossProduct(V3{1, 0, 0})
+				}
+			}
+			tangent = tangent.Normalize()
+			bitangent := normal.CrossProduct(tangent)
+
+			occlusion := float32(0)
+			for _, k := range ssaoKernel {
+				sample := position.Add(tangent.MultiplyScalar(k[0] * radius)).
+					Add(bitangent.MultiplyScalar(k[1] * radius)).
+					Add(normal.MultiplyScalar(k[2] * radius))
+
+				// find the surface the camera sees in the direction of the sample
+				clip := projection.MultiplyV4(V4{sample[0], sample[1], sample[2], 1})
+				if clip[3] <= 0 {
+					continue
+				}
+				sx := (clip[0]/clip[3] + 1) / 2 * float32(width)
+				sy := (1 - clip[1]/clip[3]) / 2 * float32(height)
+				if sx < 0 || sy < 0 || sx >= float32(width) || sy >= float32(height) {
+					continue
+				}
+				z, ok := surfaceZ(sx, sy)
+				if !ok {
+					continue
+				}
+
+				// view space z grows towards the camera, surfaces far in front of the sample don't count
+				if z >= sample[2]+ssaoBias*radius {
+					occlusion += smoothstep(0, 1, radius/abs(position[2]-z))
+				}
+			}
+			r.aoBlur[py*width+px] = clamp(strength * occlusion / ssaoKernelSize)
+		}
+	})
+
+	// average the noise tile away
+	r.parallelRows(height, func(py int) {
+		for px := 0; px < width; px++ {
+			sum, count := float32(0), 0
+			for dy := -ssaoNoiseSize / 2; dy < ssaoNoiseSize/2; dy++ {
+				for dx := -ssaoNoiseSize / 2; dx < ssaoNoiseSize/2; dx++ {
+					x, y := px+dx, py+dy
+					if x < 0 || y < 0 || x >= width || y >= height || positions[y*width+x][3] == 0 {
+						continue
+					}
+					sum += r.aoBlur[y*width+x]
+					count++
+				}
+			}
+			if count == 0 {
+				r.ao[py*width+px] = 0
+			} else {
+				r.ao[py*width+px] = sum / float32(count)
+			}
+		}
+	})
+	return nil
+}
+
+// resolveAmbientOcclusion draws the ambient light left by SSAO
+func (r *Renderer) resolveAmbientOcclusion(img *image.NRGBA) {
+	width := img.Bounds().Dx()
+	for i, occlusion := range r.ao {
+		v := 1 - occlusion
+		img.SetNRGBA(i%width, i/width, toNRGBA(V4{v, v, v, 1}))
+	}
+}
+
+// reconstructNormal estimates the normal at a pixel from its neighbours' positions,
+// on each axis it uses the neighbour closest in depth so that edges don't bend the normal
+func reconstructNormal(positions []V4, width, height, px, py int) V3 {
+	at := func(x, y int) (V3, bool) {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return V3{}, false
+		}
+		p := positions[y*width+x]
+		return V3{p[0], p[1], p[2]}, p[3] != 0
+	}
+	center, _ := at(px, py)
+
+	derivative := func(dx, dy int) V3 {
+		next, okNext := at(px+dx, py+dy)
+		prev, okPrev := at(px-dx, py-dy)
+		if okNext && (!okPrev || abs(next[2]-center[2]) <= abs(center[2]-prev[2])) {
+			return next.Subtract(center)
+		}
+		if okPrev {
+			return center.Subtract(prev)
+		}
+		return V3{}
+	}
+
+	// pixel rows go down the screen
+	normal := derivative(1, 0).CrossProduct(derivative(0, -1))
+	if normal.Length() == 0 {
+		return V3{0, 0, 1}
+	}
+	normal = normal.Normalize()
+	if normal.DotProduct(center) > 0 {
+		normal = normal.Negate()
+	}
+	return normal
+}
@@ -0,0 +1,104 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+)
+
+// Texture is an image together with its mipmaps, every level is half the size of the previous one
+// down to a single pixel
+type Texture struct {
+	Levels []*image.NRGBA
+	// the colors are sRGB encoded and converted to linear when sampled, alpha is always linear
+	SRGB bool
+
+	// some texels aren't fully opaque
+	translucent bool
+	// some texels are neither fully opaque nor fully transparent
+	partial bool
+}
+
+// NewTexture generates the mipmap chain of an image holding linear data, the image becomes the first level
+func NewTexture(img *image.NRGBA) *Texture {
+	return newTexture(img, false)
+}
+
+// NewSRGBTexture generates the mipmap chain of an image holding sRGB colors, like photos and color maps
+func NewSRGBTexture(img *image.NRGBA) *Texture {
+	return newTexture(img, true)
+}
+
+func newTexture(img *image.NRGBA, srgb bool) *Texture {
+	t := &Texture{Levels: []*image.NRGBA{img}, SRGB: srgb}
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+img.Rect.Dx()*4]
+		for x := 3; x < len(row); x += 4 {
+			if row[x] != 255 {
+				t.translucent = true
+				if row[x] != 0 {
+					t.partial = true
+				}
+			}
+		}
+	}
+
+	for img.Rect.Dx() > 1 || img.Rect.Dy() > 1 {
+		img = downsample(img, srgb)
+		t.Levels = append(t.Levels, img)
+	}
+	return t
+}
+
+// downsample halves an image by averaging 2x2 blocks of pixels, odd sizes reuse the last row or column
+// sRGB colors are averaged in linear space
+func downsample(src *image.NRGBA, srgb bool) *image.NRGBA {
+	width := src.Rect.Dx()
+	height := src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, maxInt(1, width/2), maxInt(1, height/2)))
+
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			var sum [4]float32
+			for _, d := range [4]image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				sx := minInt(2*x+d.X, width-1)
+				sy := minInt(2*y+d.Y, height-1)
+				offset := sy*src.Stride + sx*4
+				for i := range sum {
+					v := src.Pix[offset+i]
+					if srgb && i < 3 {
+						sum[i] += srgbTable[v]
+					} else {
+						sum[i] += float32(v) / 255
+					}
+				}
+			}
+			for i := range sum {
+				sum[i] /= 4
+				if srgb && i < 3 {
+					sum[i] = linearToSRGB(sum[i])
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				uint8(sum[0]*255 + 0.5),
+				uint8(sum[1]*255 + 0.5),
+				uint8(sum[2]*255 + 0.5),
+				uint8(sum[3]*255 + 0.5),
+			})
+		}
+	}
+	return dst
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+package raster
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+const defaultTileSize = 64
+
+// rasterize bins primitives into screen tiles and rasterizes the tiles in parallel
+// every pixel belongs to exactly one tile and each bin keeps submission order, so the
+// result is the same no matter how many workers are used
+func (r *Renderer) rasterize(prims []primitive) {
+	width := r.fb.width
+	height := r.fb.height
+
+	tileSize := r.TileSize
+	if tileSize <= 0 {
+		tileSize = defaultTileSize
+	}
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+
+	if len(r.bins) != tilesX*tilesY {
+		r.bins = make([][]int32, tilesX*tilesY)
+	}
+	bins := r.bins
+	for i := range bins {
+		bins[i] = bins[i][:0]
+	}
+
+	for i := range prims {
+		b := prims[i].bounds
+		for ty := b.Min.Y / tileSize; ty <= (b.Max.Y-1)/tileSize; ty++ {
+			for tx := b.Min.X / tileSize; tx <= (b.Max.X-1)/tileSize; tx++ {
+				bins[ty*tilesX+tx] = append(bins[ty*tilesX+tx], int32(i))
+			}
+		}
+	}
+
+	workers := r.workers()
+
+	tiles := make(chan int, len(bins))
+	for i, bin := range bins {
+		if len(bin) > 0 {
+			tiles <- i
+		}
+	}
+	close(tiles)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vertexShader, _ := r.shaders()
+			scratch := make([]float32, 6*vertexShader.Varyings())
+			for i := range tiles {
+				tx := i % tilesX
+				ty := i / tilesX
+				bounds := image.Rect(tx*tileSize, ty*tileSize, (tx+1)*tileSize, (ty+1)*tileSize)
+				for _, p := range bins[i] {
+					r.drawTriangle(&prims[p], bounds, scratch)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// parallelRows calls f for every row of an image of the given height, spread over the workers
+func (r *Renderer) parallelRows(height int, f func(y int)) {
+	rows := make(chan int, height)
+	for y := 0; y < height; y++ {
+		rows <- y
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	for w := 0; w < r.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				f(y)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Renderer) workers() int {
+	if r.Workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return r.Workers
+}
@@ -0,0 +1,58 @@
+package raster
+
+import (
+	"math"
+
+	. "matrix"
+)
+
+// Tonemap maps the unbounded linear colors of the framebuffer to the 0-1 range of the image
+type Tonemap int
+
+const (
+	// clamp colors above 1
+	TonemapNone Tonemap = iota
+	// c / (1 + c), never quite reaches white
+	TonemapReinhard
+	// approximation of the ACES filmic curve
+	TonemapACES
+)
+
+func (t Tonemap) apply(c V4) V4 {
+	for i := 0; i < 3; i++ {
+		x := max(0, c[i])
+		switch t {
+		case TonemapReinhard:
+			x = x / (1 + x)
+		case TonemapACES:
+			// https://knarkowicz.wordpress.com/2016/01/06/aces-filmic-tone-mapping-curve/
+			x = (x * (2.51*x + 0.03)) / (x*(2.43*x+0.59) + 0.14)
+		}
+		c[i] = clamp(x)
+	}
+	return c
+}
+
+// srgbTable converts 8-bit sRGB values to linear
+var srgbTable [256]float32
+
+func init() {
+	for i := range srgbTable {
+		srgbTable[i] = srgbToLinear(float32(i) / 255)
+	}
+}
+
+// https://en.wikipedia.org/wiki/SRGB
+func srgbToLinear(c float32) float32 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return float32(math.Pow(float64((c+0.055)/1.055), 2.4))
+}
+
+func linearToSRGB(c float32) float32 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*float32(math.Pow(float64(c), 1/2.4)) - 0.055
+}
@@ -0,0 +1,85 @@
+package raster
+
+import (
+	"sync"
+
+	. "matrix"
+)
+
+// number of triangles handed to a vertex worker at a time
+const vertexChunkSize = 256
+
+// ProcessedTriangle is a triangle after the vertex stage, positions are in clip space
+type ProcessedTriangle struct {
+	Vertices [3]V4
+	Varyings [3][]float32
+	Triangle *Triangle
+	// index of the triangle in the scene
+	Index int
+}
+
+func processTriangle(t *Triangle, u *Uniforms, shader VertexShader, out *ProcessedTriangle) {
+	for i := 0; i < 3; i++ {
+		v := Vertex{
+			Position:     t.Vertices[i],
+			TextureCoord: t.TextureCoords[i],
+			Normal:       t.Normals[i],
+			Tangent:      t.Tangents[i],
+		}
+		out.Vertices[i] = shader.ShadeVertex(u, &v, out.Varyings[i])
+	}
+	out.Triangle = t
+}
+
+// ProcessVertices is the vertex stage, it runs the vertex shader on every triangle, splitting the
+// work into chunks that are processed concurrently
+// it can be run on its own, e.g. to benchmark it, the returned slice is reused by the next call
+func (r *Renderer) ProcessVertices(triangles []Triangle, u *Uniforms) []ProcessedTriangle {
+	vertexShader, _ := r.shaders()
+	varyingCount := vertexShader.Varyings()
+	if cap(r.processed) < len(triangles) {
+		r.processed = make([]ProcessedTriangle, len(triangles))
+	}
+	if cap(r.varyings) < len(triangles)*3*varyingCount {
+		r.varyings = make([]float32, len(triangles)*3*varyingCount)
+	}
+	out := r.processed[:len(triangles)]
+
+	chunks := (len(triangles) + vertexChunkSize - 1) / vertexChunkSize
+	workers := r.workers()
+	if workers > chunks {
+		workers = chunks
+	}
+
+	next := make(chan int, chunks)
+	for i := 0; i < chunks; i++ {
+		next <- i
+	}
+	close(next)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range next {
+				start := chunk * vertexChunkSize
+				end := start + vertexChunkSize
+				if end > len(triangles) {
+					end = len(triangles)
+				}
+				for i := start; i < end; i++ {
+					for j := 0; j < 3; j++ {
+						offset := (i*3 + j) * varyingCount
+						out[i].Varyings[j] = r.varyings[offset : offset+varyingCount : offset+varyingCount]
+					}
+					processTriangle(&triangles[i], u, vertexShader, &out[i])
+					out[i].Index = i
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return out
+}
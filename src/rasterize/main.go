@@ -0,0 +1,334 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"path"
+	"raster"
+	"strconv"
+	"strings"
+
+	. "matrix"
+)
+
+var (
+	objPath    = flag.String("obj", "data/cat.obj", "OBJ file to render")
+	outPath    = flag.String("out", "out.png", "output image (.png, .jpg or .jpeg)")
+	size       = flag.String("size", "512x512", "output size as WIDTHxHEIGHT")
+	position   = flag.String("camera", "0,0,5", "camera position as x,y,z")
+	pitch      = flag.Float64("pitch", 0, "camera rotation around the x axis in degrees")
+	yaw        = flag.Float64("yaw", 0, "camera rotation around the y axis in degrees")
+	fov        = flag.Float64("fov", 70, "vertical field of view in degrees")
+	near       = flag.Float64("near", 1, "near clipping plane")
+	far        = flag.Float64("far", 150, "far clipping plane")
+	scale      = flag.Float64("scale", 1, "uniform scale applied to the model")
+	rotate     = flag.String("rotate", "0,0,0", "model rotation around the x,y,z axes in degrees")
+	quality    = flag.Int("quality", 90, "JPEG quality")
+	samples    = flag.Int("samples", 1, "samples per pixel for anti-aliasing (1, 2, 4 or 8)")
+	cull       = flag.String("cull", "back", "face culling: none, back or front")
+	winding    = flag.String("frontface", "ccw", "winding of front faces: ccw or cw")
+	twoSided   = flag.Bool("twosided", false, "light back faces as if their normals were flipped")
+	polygon    = flag.String("polygon", "fill", "polygon mode: fill, line or both")
+	smooth     = flag.Bool("smoothlines", false, "anti-alias lines")
+	pointSize  = flag.Float64("pointsize", 1, "width of points in pixels")
+	pointShape = flag.String("pointshape", "square", "point sprite shape: square or round")
+	coverage   = flag.Bool("alphacoverage", false, "use alpha to coverage for cutout materials when multisampling")
+	filter     = flag.String("filter", "bilinear", "texture filter: nearest or bilinear")
+	wrap       = flag.String("wrap", "repeat", "texture wrap mode: repeat, clamp or mirror")
+	mip        = flag.String("mip", "linear", "mipmap filter: none, nearest or linear")
+	lodBias    = flag.Float64("lodbias", 0, "bias added to the texture level of detail")
+	exposure   = flag.Float64("exposure", 1, "scale applied to the linear colors before tonemapping")
+	tonemap    = flag.String("tonemap", "none", "tonemapping operator: none, reinhard or aces")
+	debug      = flag.String("debug", "none", "debug view: none, depth, normals, uv, overdraw, triangles, objects or ao")
+	deferred   = flag.Bool("deferred", false, "light every pixel once from a G-buffer")
+	gbuffer    = flag.String("gbuffer", "", "with -deferred, save the G-buffer channels as PREFIX-position.png, PREFIX-normal.png, ...")
+	shadows    = flag.Bool("shadows", false, "shadow maps for the lights")
+	ssao       = flag.Bool("ssao", false, "screen-space ambient occlusion")
+	ssaoRadius = flag.Float64("ssaoradius", 0.5, "view space distance searched for occluders")
+	ssaoPower  = flag.Float64("ssaostrength", 1, "darkness of fully occluded ambient light")
+	fogMode    = flag.String("fog", "none", "distance fog: none, linear, exp or exp2")
+	fogColor   = flag.String("fogcolor", "0.5,0.6,0.7", "linear fog color as r,g,b, also used as the background")
+	fogStart   = flag.Float64("fogstart", 10, "view depth where linear fog begins")
+	fogEnd     = flag.Float64("fogend", 100, "view depth where linear fog becomes opaque")
+	fogDensity = flag.Float64("fogdensity", 0.05, "density of exponential fog")
+	heightFog  = flag.Float64("heightfog", 0, "density of height fog at -fogheight, 0 disables it")
+	fogHeight  = flag.Float64("fogheight", 0, "world height of the height fog's reference density")
+	fogFalloff = flag.Float64("fogfalloff", 1, "how fast height fog thins out going up")
+)
+
+var filters = map[string]raster.Filter{
+	"nearest":  raster.FilterNearest,
+	"bilinear": raster.FilterBilinear,
+}
+
+var mipFilters = map[string]raster.MipFilter{
+	"none":    raster.MipNone,
+	"nearest": raster.MipNearest,
+	"linear":  raster.MipLinear,
+}
+
+var cullModes = map[string]raster.CullMode{
+	"none":  raster.CullNone,
+	"back":  raster.CullBack,
+	"front": raster.CullFront,
+}
+
+var windings = map[string]raster.Winding{
+	"ccw": raster.CounterClockwise,
+	"cw":  raster.Clockwise,
+}
+
+var polygonModes = map[string]raster.PolygonMode{
+	"fill": raster.PolygonFill,
+	"line": raster.PolygonLine,
+	"both": raster.PolygonFillAndLine,
+}
+
+var pointShapes = map[string]raster.PointShape{
+	"square": raster.PointSquare,
+	"round":  raster.PointRound,
+}
+
+var tonemaps = map[string]raster.Tonemap{
+	"none":     raster.TonemapNone,
+	"reinhard": raster.TonemapReinhard,
+	"aces":     raster.TonemapACES,
+}
+
+var debugViews = map[string]raster.DebugView{
+	"none":      raster.DebugNone,
+	"depth":     raster.DebugDepth,
+	"normals":   raster.DebugNormals,
+	"uv":        raster.DebugUVs,
+	"overdraw":  raster.DebugOverdraw,
+	"triangles": raster.DebugTriangles,
+	"objects":   raster.DebugObjects,
+	"ao":        raster.DebugAmbientOcclusion,
+}
+
+var gbufferChannels = map[string]raster.GBufferChannel{
+	"position": raster.GBufferPosition,
+	"normal":   raster.GBufferNormal,
+	"albedo":   raster.GBufferAlbedo,
+	"material": raster.GBufferMaterial,
+}
+
+var fogModes = map[string]raster.FogMode{
+	"none":   raster.FogNone,
+	"linear": raster.FogLinear,
+	"exp":    raster.FogExponential,
+	"exp2":   raster.FogExponentialSquared,
+}
+
+var wraps = map[string]raster.Wrap{
+	"repeat": raster.WrapRepeat,
+	"clamp":  raster.WrapClampToEdge,
+	"mirror": raster.WrapMirroredRepeat,
+}
+
+func parseSize(s string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size %q", s)
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q", s)
+	}
+	return width, height, nil
+}
+
+func parseV3(s string) (V3, error) {
+	v := V3{}
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid vector %q", s)
+	}
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return v, err
+		}
+		v[i] = float32(f)
+	}
+	return v, nil
+}
+
+func radians(degrees float32) float32 {
+	return degrees / 180 * math.Pi
+}
+
+func save(img image.Image, outPath string) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(path.Ext(outPath)) {
+	case ".png":
+		err = png.Encode(f, img)
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: *quality})
+	default:
+		err = fmt.Errorf("unsupported output format: %s", outPath)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	flag.Parse()
+
+	width, height, err := parseSize(*size)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pos, err := parseV3(*position)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rot, err := parseV3(*rotate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, ok := filters[*filter]
+	if !ok {
+		log.Fatalf("unknown filter %q", *filter)
+	}
+
+	w, ok := wraps[*wrap]
+	if !ok {
+		log.Fatalf("unknown wrap mode %q", *wrap)
+	}
+
+	m, ok := mipFilters[*mip]
+	if !ok {
+		log.Fatalf("unknown mipmap filter %q", *mip)
+	}
+
+	cullMode, ok := cullModes[*cull]
+	if !ok {
+		log.Fatalf("unknown cull mode %q", *cull)
+	}
+
+	frontFace, ok := windings[*winding]
+	if !ok {
+		log.Fatalf("unknown winding %q", *winding)
+	}
+
+	polygonMode, ok := polygonModes[*polygon]
+	if !ok {
+		log.Fatalf("unknown polygon mode %q", *polygon)
+	}
+
+	shape, ok := pointShapes[*pointShape]
+	if !ok {
+		log.Fatalf("unknown point shape %q", *pointShape)
+	}
+
+	tm, ok := tonemaps[*tonemap]
+	if !ok {
+		log.Fatalf("unknown tonemap %q", *tonemap)
+	}
+
+	debugView, ok := debugViews[*debug]
+	if !ok {
+		log.Fatalf("unknown debug view %q", *debug)
+	}
+
+	fogM, ok := fogModes[*fogMode]
+	if !ok {
+		log.Fatalf("unknown fog mode %q", *fogMode)
+	}
+	fogC, err := parseV3(*fogColor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scene, err := raster.LoadScene(*objPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, t := range scene.Triangles {
+		t.Material.Sampler = raster.Sampler{Filter: f, MipFilter: m, WrapU: w, WrapV: w, LODBias: float32(*lodBias)}
+	}
+	for i := range scene.Lights {
+		scene.Lights[i].CastShadows = *shadows
+	}
+	scene.Fog = raster.Fog{
+		Mode:          fogM,
+		Color:         fogC,
+		Start:         float32(*fogStart),
+		End:           float32(*fogEnd),
+		Density:       float32(*fogDensity),
+		HeightDensity: float32(*heightFog),
+		Height:        float32(*fogHeight),
+		HeightFalloff: float32(*fogFalloff),
+	}
+	s := float32(*scale)
+	scene.Model = IdentityM4.Scale(V3{s, s, s}).RotateX(radians(rot[0])).RotateY(radians(rot[1])).RotateZ(radians(rot[2]))
+
+	camera := raster.NewCamera()
+	camera.Position = V4{pos[0], pos[1], pos[2]}
+	camera.XRotation = radians(float32(*pitch))
+	camera.YRotation = radians(float32(*yaw))
+	camera.FieldOfView = radians(float32(*fov))
+	camera.Near = float32(*near)
+	camera.Far = float32(*far)
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	renderer := raster.NewRenderer()
+	renderer.Samples = *samples
+	renderer.AlphaToCoverage = *coverage
+	renderer.CullMode = cullMode
+	renderer.FrontFace = frontFace
+	renderer.TwoSided = *twoSided
+	renderer.PolygonMode = polygonMode
+	renderer.SmoothLines = *smooth
+	renderer.PointSize = float32(*pointSize)
+	renderer.PointShape = shape
+	renderer.Exposure = float32(*exposure)
+	renderer.Tonemap = tm
+	renderer.DebugView = debugView
+	renderer.Deferred = *deferred
+	renderer.SSAO = *ssao
+	renderer.SSAORadius = float32(*ssaoRadius)
+	renderer.SSAOStrength = float32(*ssaoPower)
+	if err := renderer.Render(img, scene, camera); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := save(img, *outPath); err != nil {
+		log.Fatal(err)
+	}
+
+	if *gbuffer != "" {
+		for name, channel := range gbufferChannels {
+			if err := renderer.ExportGBuffer(img, channel); err != nil {
+				log.Fatal(err)
+			}
+			if err := save(img, *gbuffer+"-"+name+".png"); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
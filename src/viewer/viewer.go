@@ -8,10 +8,14 @@ import (
 	"image/draw"
 	"image/gif"
 	"log"
+	"math"
 	"os"
+	"raster"
 	"runtime"
 	"strings"
 
+	. "matrix"
+
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
 )
@@ -20,6 +24,12 @@ var keys = map[glfw.Key]bool{}
 var window *glfw.Window
 var size = 512
 
+var camera = raster.NewCamera()
+var renderer = raster.NewRenderer()
+var rotation float32 = 0.0
+var shadows = false
+var frame = 0
+
 const record = true
 
 func init() {
@@ -49,6 +59,23 @@ func main() {
 		switch action {
 		case glfw.Press:
 			keys[key] = true
+			switch key {
+			case glfw.KeyM:
+				// cycle through 1x, 2x, 4x and 8x anti-aliasing
+				renderer.Samples = renderer.Samples * 2 % 15
+			case glfw.KeyF:
+				// cycle through shaded, wireframe and wireframe over shaded
+				renderer.PolygonMode = (renderer.PolygonMode + 1) % 3
+			case glfw.KeyL:
+				renderer.SmoothLines = !renderer.SmoothLines
+			case glfw.KeyV:
+				// cycle through the shaded image and the debug views
+				renderer.DebugView = (renderer.DebugView + 1) % (raster.DebugAmbientOcclusion + 1)
+			case glfw.KeyG:
+				renderer.Deferred = !renderer.Deferred
+			case glfw.KeyH:
+				shadows = !shadows
+			}
 		case glfw.Release:
 			keys[key] = false
 		}
@@ -95,7 +122,9 @@ func main() {
 	gl.DepthFunc(gl.LESS)
 	gl.ClearColor(1.0, 1.0, 1.0, 1.0)
 
-	if err := setup(); err != nil {
+	scene, err := raster.LoadScene("data/cat.obj")
+	// scene, err := raster.LoadScene("data/dabrovic-sponza/sponza.obj")
+	if err != nil {
 		panic(err)
 	}
 
@@ -125,7 +154,6 @@ func main() {
 	lastFrame := glfw.GetTime()
 
 	images := []*image.NRGBA{}
-	frame := 0
 	for !window.ShouldClose() {
 		currentFrame := glfw.GetTime()
 
@@ -137,7 +165,13 @@ func main() {
 
 		gl.BindVertexArray(vao)
 
-		if err := render(img, currentFrame-lastFrame); err != nil {
+		update(currentFrame - lastFrame)
+		scene.Model = IdentityM4.Scale(V3{3, 3, 3}).RotateY(rotation).RotateX(rotation).Translate(V3{-0.1, -0.5, -0.5})
+		for i := range scene.Lights {
+			scene.Lights[i].CastShadows = shadows
+		}
+
+		if err := renderer.Render(img, scene, camera); err != nil {
 			log.Fatal(err)
 		}
 
@@ -181,7 +215,6 @@ func main() {
 			c.R = c.R & mask
 			c.G = c.G & mask
 			c.B = c.B & mask
-			c.A = c.A
 			result[c] = true
 		}
 		return result
@@ -214,6 +247,53 @@ func main() {
 	}
 }
 
+func update(elapsed float64) {
+	dx, dy := window.GetCursorPos()
+	window.SetCursorPos(0, 0)
+	camera.YRotation += float32(dx / 100)
+	camera.XRotation += float32(dy / 100)
+
+	if camera.XRotation > math.Pi/2 {
+		camera.XRotation = math.Pi / 2
+	}
+	if camera.XRotation < -math.Pi/2 {
+		camera.XRotation = -math.Pi / 2
+	}
+
+	delta := V4{}
+	if keys[glfw.KeyD] {
+		delta = V4{1, 0, 0, 0}
+	}
+
+	if keys[glfw.KeyA] {
+		delta = V4{-1, 0, 0, 0}
+	}
+
+	if keys[glfw.KeyW] {
+		delta = V4{0, 0, -1, 0}
+	}
+
+	if keys[glfw.KeyS] {
+		delta = V4{0, 0, 1, 0}
+	}
+
+	if keys[glfw.KeyE] {
+		rotation += 0.1
+	}
+
+	if keys[glfw.KeyQ] {
+		rotation -= 0.1
+	}
+
+	if delta != (V4{}) {
+		camera.Move(delta.MultiplyScalar(float32(10 * elapsed)))
+	}
+
+	if record {
+		rotation = float32(frame) / 100 * math.Pi
+	}
+}
+
 func newProgram(vertexShaderSource, fragmentShaderSource string) (uint32, error) {
 	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
 	if err != nil {